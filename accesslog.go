@@ -0,0 +1,372 @@
+package rsrp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/quells/rsrp/relay"
+)
+
+// An AccessLogEntry records a single proxied request or WebSocket session
+type AccessLogEntry struct {
+	Time          time.Time
+	ClientIP      string
+	Method        string
+	Host          string
+	Path          string
+	Query         string
+	Protocol      string
+	Route         string
+	Upstream      string
+	Status        int
+	BytesSent     int64
+	BytesReceived int64
+	Duration      time.Duration
+	Referer       string
+	UserAgent     string
+	RequestID     string
+
+	// WebSocket sessions are logged when they close
+	WebSocket        bool
+	MessagesInbound  int64
+	MessagesOutbound int64
+}
+
+// An AccessLogger records AccessLogEntries
+type AccessLogger interface {
+	Log(entry AccessLogEntry)
+}
+
+// NewAccessLogger converts an AccessLogConfig to an AccessLogger.
+// Each output file is opened once and shared by every AccessLogger writing to it,
+// so converting a Config again when it is reloaded does not open another descriptor.
+func NewAccessLogger(config AccessLogConfig) (logger AccessLogger, err error) {
+	var out io.Writer
+	switch config.Output {
+	case "", "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		out, err = openLogFile(config.Output)
+		if err != nil {
+			return
+		}
+	}
+
+	switch config.Format {
+	case "", "json":
+		logger = NewJSONLogger(out)
+	case "common":
+		logger = NewCommonLogger(out)
+	case "combined":
+		logger = NewCombinedLogger(out)
+	case "template":
+		logger, err = NewTemplateLogger(out, config.Template)
+	default:
+		err = fmt.Errorf("unknown access log format %q", config.Format)
+	}
+
+	return
+}
+
+// logFiles holds the access log files which have been opened, by absolute path.
+// They stay open so that requests still using the rules of an old Config can log to them.
+var logFiles = struct {
+	sync.Mutex
+	files map[string]*os.File
+}{files: make(map[string]*os.File)}
+
+// openLogFile opens an access log file for appending, or returns the file already opened for the path
+func openLogFile(path string) (file *os.File, err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return
+	}
+
+	logFiles.Lock()
+	defer logFiles.Unlock()
+
+	if file = logFiles.files[path]; file != nil {
+		return
+	}
+
+	file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	logFiles.files[path] = file
+	return
+}
+
+// lineWriter serializes whole lines to a shared io.Writer
+type lineWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (lw *lineWriter) writeLine(line []byte) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	lw.out.Write(append(line, '\n'))
+}
+
+// JSONLogger writes each AccessLogEntry as a line of JSON
+type JSONLogger struct {
+	lw *lineWriter
+}
+
+// NewJSONLogger creates a JSONLogger writing to out
+func NewJSONLogger(out io.Writer) JSONLogger {
+	return JSONLogger{&lineWriter{out: out}}
+}
+
+type jsonEntry struct {
+	Time             string  `json:"time"`
+	ClientIP         string  `json:"clientIP"`
+	Method           string  `json:"method"`
+	Host             string  `json:"host"`
+	Path             string  `json:"path"`
+	Query            string  `json:"query,omitempty"`
+	Protocol         string  `json:"protocol"`
+	Route            string  `json:"route"`
+	Upstream         string  `json:"upstream"`
+	Status           int     `json:"status"`
+	BytesSent        int64   `json:"bytesSent"`
+	BytesReceived    int64   `json:"bytesReceived"`
+	DurationMs       float64 `json:"durationMs"`
+	Referer          string  `json:"referer,omitempty"`
+	UserAgent        string  `json:"userAgent,omitempty"`
+	RequestID        string  `json:"requestId,omitempty"`
+	WebSocket        bool    `json:"websocket,omitempty"`
+	MessagesInbound  int64   `json:"messagesInbound,omitempty"`
+	MessagesOutbound int64   `json:"messagesOutbound,omitempty"`
+}
+
+// Log conforms JSONLogger to AccessLogger
+func (l JSONLogger) Log(entry AccessLogEntry) {
+	line, err := json.Marshal(jsonEntry{
+		Time:             entry.Time.Format(time.RFC3339Nano),
+		ClientIP:         entry.ClientIP,
+		Method:           entry.Method,
+		Host:             entry.Host,
+		Path:             entry.Path,
+		Query:            entry.Query,
+		Protocol:         entry.Protocol,
+		Route:            entry.Route,
+		Upstream:         entry.Upstream,
+		Status:           entry.Status,
+		BytesSent:        entry.BytesSent,
+		BytesReceived:    entry.BytesReceived,
+		DurationMs:       float64(entry.Duration) / float64(time.Millisecond),
+		Referer:          entry.Referer,
+		UserAgent:        entry.UserAgent,
+		RequestID:        entry.RequestID,
+		WebSocket:        entry.WebSocket,
+		MessagesInbound:  entry.MessagesInbound,
+		MessagesOutbound: entry.MessagesOutbound,
+	})
+	if err != nil {
+		return
+	}
+
+	l.lw.writeLine(line)
+}
+
+// CommonLogger writes each AccessLogEntry in the Common Log Format,
+// or the Combined Log Format if Combined is set
+type CommonLogger struct {
+	Combined bool
+	lw       *lineWriter
+}
+
+// NewCommonLogger creates a CommonLogger writing to out
+func NewCommonLogger(out io.Writer) CommonLogger {
+	return CommonLogger{false, &lineWriter{out: out}}
+}
+
+// NewCombinedLogger creates a CommonLogger using the Combined Log Format writing to out
+func NewCombinedLogger(out io.Writer) CommonLogger {
+	return CommonLogger{true, &lineWriter{out: out}}
+}
+
+// Log conforms CommonLogger to AccessLogger
+func (l CommonLogger) Log(entry AccessLogEntry) {
+	target := entry.Path
+	if entry.Query != "" {
+		target += "?" + entry.Query
+	}
+
+	line := fmt.Sprintf("%s - - [%s] %q %d %s",
+		orDash(entry.ClientIP),
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.Method+" "+target+" "+entry.Protocol,
+		entry.Status,
+		orDash(strconv.FormatInt(entry.BytesSent, 10)),
+	)
+
+	if l.Combined {
+		line += fmt.Sprintf(" %q %q", orDash(entry.Referer), orDash(entry.UserAgent))
+	}
+
+	l.lw.writeLine([]byte(line))
+}
+
+func orDash(s string) string {
+	if s == "" || s == "0" {
+		return "-"
+	}
+	return s
+}
+
+// TemplateLogger writes each AccessLogEntry using a text/template, one line per entry
+type TemplateLogger struct {
+	template *template.Template
+	lw       *lineWriter
+}
+
+// NewTemplateLogger creates a TemplateLogger writing to out.
+// The template is executed with an AccessLogEntry, for example "{{.Method}} {{.Path}} {{.Status}}".
+func NewTemplateLogger(out io.Writer, text string) (logger TemplateLogger, err error) {
+	var tmpl *template.Template
+	tmpl, err = template.New("access log").Parse(text)
+	if err != nil {
+		return
+	}
+
+	logger = TemplateLogger{tmpl, &lineWriter{out: out}}
+	return
+}
+
+// Log conforms TemplateLogger to AccessLogger
+func (l TemplateLogger) Log(entry AccessLogEntry) {
+	var line bufferWriter
+	if err := l.template.Execute(&line, entry); err != nil {
+		return
+	}
+
+	l.lw.writeLine(line)
+}
+
+type bufferWriter []byte
+
+func (b *bufferWriter) Write(p []byte) (int, error) {
+	*b = append(*b, p...)
+	return len(p), nil
+}
+
+// newAccessLogEntry fills in the parts of an AccessLogEntry which come from the incoming request
+func (rule RouteRule) newAccessLogEntry(r *http.Request, start time.Time, upstream string) AccessLogEntry {
+	clientIP := ""
+	if ip := rule.Forwarded.ClientIP(r); ip != nil {
+		clientIP = ip.String()
+	}
+
+	return AccessLogEntry{
+		Time:      start,
+		ClientIP:  clientIP,
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Protocol:  r.Proto,
+		Route:     rule.name(),
+		Upstream:  upstream,
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+		RequestID: RequestID(r),
+		Duration:  time.Since(start),
+	}
+}
+
+// logWebSocket returns relay.Options which log the WebSocket session when it closes
+func (rule RouteRule) logWebSocket(options relay.Options, r *http.Request, upstream string) relay.Options {
+	if rule.AccessLog == nil {
+		return options
+	}
+
+	next := options.OnClose
+	options.OnClose = func(stats relay.Stats) {
+		entry := rule.newAccessLogEntry(r, stats.Start, upstream)
+		entry.Status = http.StatusSwitchingProtocols
+		entry.Duration = stats.Duration
+		entry.WebSocket = true
+		entry.BytesSent = stats.BytesOutbound
+		entry.BytesReceived = stats.BytesInbound
+		entry.MessagesInbound = stats.MessagesInbound
+		entry.MessagesOutbound = stats.MessagesOutbound
+		rule.AccessLog.Log(entry)
+
+		if next != nil {
+			next(stats)
+		}
+	}
+
+	return options
+}
+
+// responseRecorder records the status and size of a response as it is written.
+// It passes through http.Flusher and http.Hijacker so streaming and WebSockets still work.
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (n int, err error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err = rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+	return
+}
+
+// Flush conforms responseRecorder to http.Flusher
+func (rec *responseRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack conforms responseRecorder to http.Hijacker
+func (rec *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	rec.hijacked = true
+	return hijacker.Hijack()
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (cr *countingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.ReadCloser.Read(p)
+	cr.bytes += int64(n)
+	return
+}
@@ -0,0 +1,204 @@
+package rsrp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/quells/rsrp"
+	"github.com/quells/rsrp/relay"
+)
+
+// channelLogger sends each AccessLogEntry to a channel
+type channelLogger chan rsrp.AccessLogEntry
+
+func (l channelLogger) Log(entry rsrp.AccessLogEntry) {
+	l <- entry
+}
+
+func nextEntry(t *testing.T, entries channelLogger) rsrp.AccessLogEntry {
+	select {
+	case entry := <-entries:
+		return entry
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected an access log entry")
+	}
+	return rsrp.AccessLogEntry{}
+}
+
+func TestRouteRule_AccessLog(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	defer backend.Close()
+
+	entries := make(channelLogger, 1)
+	rule, _ := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:       "^/items$",
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/items)$", Output: "$1"},
+		Destination: backend.URL,
+	})
+	rule.AccessLog = entries
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/items?draft=1", "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+	}
+	resp.Body.Close()
+
+	entry := nextEntry(t, entries)
+	if entry.Method != http.MethodPost || entry.Path != "/items" || entry.Query != "draft=1" {
+		t.Fatalf("AccessLog expected POST /items?draft=1, got %s %s?%s", entry.Method, entry.Path, entry.Query)
+	}
+	if entry.Route != "^/items$" || entry.Upstream != backend.URL {
+		t.Fatalf("AccessLog expected route ^/items$ to %s, got %s to %s", backend.URL, entry.Route, entry.Upstream)
+	}
+	if entry.Status != http.StatusCreated || entry.BytesSent != int64(len("created")) || entry.BytesReceived != int64(len("payload")) {
+		t.Fatalf("AccessLog expected status 201 with 7 bytes each way, got %d with %d sent and %d received", entry.Status, entry.BytesSent, entry.BytesReceived)
+	}
+	if entry.ClientIP != "127.0.0.1" {
+		t.Fatalf("AccessLog expected client IP 127.0.0.1, got %s", entry.ClientIP)
+	}
+}
+
+func TestRouteRule_AccessLogWebSocket(t *testing.T) {
+	backend := httptest.NewServer(relay.EchoServer{})
+	defer backend.Close()
+
+	entries := make(channelLogger, 1)
+	rule, _ := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:       "^/ws$",
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/ws)$", Output: ""},
+		Destination: "ws" + strings.TrimPrefix(backend.URL, "http"),
+	})
+	rule.AccessLog = entries
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("could not connect to echo server: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Fatalf("could not read message: %v", err)
+		}
+	}
+	conn.Close()
+
+	entry := nextEntry(t, entries)
+	if !entry.WebSocket || entry.Status != http.StatusSwitchingProtocols {
+		t.Fatalf("AccessLog expected a WebSocket session entry, got %+v", entry)
+	}
+	if entry.MessagesInbound != 3 || entry.MessagesOutbound != 3 || entry.BytesSent != 12 {
+		t.Fatalf("AccessLog expected 3 messages each way, got %+v", entry)
+	}
+}
+
+func TestAccessLoggers(t *testing.T) {
+	entry := rsrp.AccessLogEntry{
+		Time:      time.Date(2019, 5, 4, 13, 14, 15, 0, time.UTC),
+		ClientIP:  "203.0.113.7",
+		Method:    "GET",
+		Host:      "example.com",
+		Path:      "/abc/test",
+		Query:     "q=ok",
+		Protocol:  "HTTP/1.1",
+		Route:     "^/abc/?.*$",
+		Upstream:  "http://localhost:5001",
+		Status:    200,
+		BytesSent: 42,
+		Duration:  1500 * time.Microsecond,
+		UserAgent: "curl/7.64.1",
+	}
+
+	var buf bytes.Buffer
+
+	rsrp.NewCommonLogger(&buf).Log(entry)
+	expected := `203.0.113.7 - - [04/May/2019:13:14:15 +0000] "GET /abc/test?q=ok HTTP/1.1" 200 42` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("CommonLogger expected %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	rsrp.NewCombinedLogger(&buf).Log(entry)
+	expected = `203.0.113.7 - - [04/May/2019:13:14:15 +0000] "GET /abc/test?q=ok HTTP/1.1" 200 42 "-" "curl/7.64.1"` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("CombinedLogger expected %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	logger, err := rsrp.NewTemplateLogger(&buf, "{{.Route}} -> {{.Upstream}} {{.Status}} {{.Duration}}")
+	if err != nil {
+		t.Fatalf("NewTemplateLogger() unexpected error: %s", err.Error())
+	}
+	logger.Log(entry)
+	expected = "^/abc/?.*$ -> http://localhost:5001 200 1.5ms\n"
+	if buf.String() != expected {
+		t.Fatalf("TemplateLogger expected %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	rsrp.NewJSONLogger(&buf).Log(entry)
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("JSONLogger expected valid JSON, got %q", buf.String())
+	}
+	if decoded["route"] != "^/abc/?.*$" || decoded["status"] != 200.0 || decoded["durationMs"] != 1.5 {
+		t.Fatalf("JSONLogger expected route, status and duration, got %v", decoded)
+	}
+
+	if _, err := rsrp.NewAccessLogger(rsrp.AccessLogConfig{Format: "xml"}); err == nil {
+		t.Fatalf("NewAccessLogger() expected error for unknown format")
+	}
+}
+
+func TestNewAccessLogger_ReusesFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "access.log")
+
+	openFiles := func() int {
+		fds, err := ioutil.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip("cannot count open files on this platform")
+		}
+		return len(fds)
+	}
+
+	var loggers []rsrp.AccessLogger
+	before := openFiles()
+	for i := 0; i < 10; i++ {
+		logger, err := rsrp.NewAccessLogger(rsrp.AccessLogConfig{Format: "common", Output: output})
+		if err != nil {
+			t.Fatalf("NewAccessLogger() unexpected error: %s", err.Error())
+		}
+		loggers = append(loggers, logger)
+	}
+	if opened := openFiles() - before; opened > 1 {
+		t.Fatalf("NewAccessLogger() expected to open the output once, opened %d files", opened)
+	}
+
+	for _, logger := range loggers {
+		logger.Log(rsrp.AccessLogEntry{Method: http.MethodGet, Path: "/"})
+	}
+	data, _ := ioutil.ReadFile(output)
+	if lines := strings.Count(string(data), "\n"); lines != len(loggers) {
+		t.Fatalf("NewAccessLogger() expected every logger to write to the shared file, got %d lines", lines)
+	}
+}
@@ -0,0 +1,189 @@
+package rsrp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// A RouteStatus describes a loaded RouteRule: its compiled patterns, destinations and, if it has Metrics, live statistics
+type RouteStatus struct {
+	Index        int                 `json:"index"`
+	Name         string              `json:"name"`
+	Match        string              `json:"match"`
+	Host         string              `json:"host,omitempty"`
+	Methods      []string            `json:"methods,omitempty"`
+	Headers      map[string]string   `json:"headers,omitempty"`
+	Query        map[string]string   `json:"query,omitempty"`
+	Rewrite      RewriteRuleConfig   `json:"rewrite"`
+	Destinations []DestinationStatus `json:"destinations"`
+	Stats        *RouteStats         `json:"stats,omitempty"`
+}
+
+// A DestinationStatus describes one Destination of a route.
+// Breaker is only set if the route has a circuit breaker.
+type DestinationStatus struct {
+	URL         string                `json:"url"`
+	Weight      int                   `json:"weight"`
+	Healthy     bool                  `json:"healthy"`
+	Outstanding int64                 `json:"outstanding"`
+	Breaker     *CircuitBreakerStatus `json:"breaker,omitempty"`
+}
+
+// Status returns a snapshot of the RouteRule, which is at index in its list of rules
+func (rule RouteRule) Status(index int) (status RouteStatus) {
+	status = RouteStatus{
+		Index:        index,
+		Name:         rule.name(),
+		Match:        rule.Match.String(),
+		Methods:      rule.Methods,
+		Headers:      patternStrings(rule.Headers),
+		Query:        patternStrings(rule.Query),
+		Rewrite:      RewriteRuleConfig{Input: rule.Rewrite.Input.String(), Output: rule.Rewrite.Output},
+		Destinations: []DestinationStatus{},
+		Stats:        rule.Metrics.routeStats(rule.name()),
+	}
+	if rule.Host != nil {
+		status.Host = rule.Host.String()
+	}
+
+	if rule.Balancer == nil {
+		status.Destinations = append(status.Destinations, DestinationStatus{URL: rule.Destination, Weight: 1, Healthy: true})
+		return
+	}
+
+	for _, d := range rule.Balancer.Destinations {
+		destination := DestinationStatus{
+			URL:         d.URL,
+			Weight:      d.weight(),
+			Healthy:     d.Healthy(),
+			Outstanding: d.Outstanding(),
+		}
+		if d.Breaker != nil {
+			breaker := d.Breaker.Status()
+			breaker.Route = status.Name
+			breaker.Destination = d.URL
+			destination.Breaker = &breaker
+		}
+		status.Destinations = append(status.Destinations, destination)
+	}
+
+	return
+}
+
+// patternStrings returns the source of each compiled pattern
+func patternStrings(patterns map[string]*regexp.Regexp) map[string]string {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	sources := make(map[string]string, len(patterns))
+	for name, pattern := range patterns {
+		sources[name] = pattern.String()
+	}
+	return sources
+}
+
+// RouteStatuses returns the status of every RouteRule, in order
+func RouteStatuses(rules []RouteRule) (statuses []RouteStatus) {
+	statuses = make([]RouteStatus, len(rules))
+	for i, rule := range rules {
+		statuses[i] = rule.Status(i)
+	}
+
+	return
+}
+
+// A MatchResult describes how a request would be routed.
+// If no route matches, Route is nil.
+// Path is the rewritten path, and Destinations the URLs the request could be proxied to.
+type MatchResult struct {
+	Route        *RouteStatus `json:"route"`
+	Path         string       `json:"path,omitempty"`
+	Destinations []string     `json:"destinations,omitempty"`
+}
+
+// MatchRequest finds the RouteRule which would handle a request, without proxying it or changing any state
+func MatchRequest(rules []RouteRule, r *http.Request) (result MatchResult) {
+	for i, rule := range rules {
+		if !rule.Matches(r) {
+			continue
+		}
+
+		status := rule.Status(i)
+		result.Route = &status
+		result.Path = rule.RewritePath(r.URL.Path)
+		for _, d := range status.Destinations {
+			result.Destinations = append(result.Destinations, d.URL+result.Path)
+		}
+		return
+	}
+
+	return
+}
+
+// AdminHandler returns an http.Handler which describes the Router's current RouteRules as JSON.
+// GET /routes lists every route with its destinations, their health and circuit breaker states,
+// and, if the Router uses Metrics, live statistics.
+// GET /match?method=GET&host=example.com&path=/api/orders does a dry run of routing a request;
+// each header parameter such as header=Accept:text/html adds a request header.
+// Neither endpoint changes state, but both reveal internal destination URLs,
+// and /match lets callers map which requests reach which backends, so it must not be served alongside routed traffic.
+func (router *Router) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/routes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, RouteStatuses(router.Rules()))
+	})
+
+	mux.HandleFunc("/match", func(w http.ResponseWriter, r *http.Request) {
+		req, err := dryRunRequest(r.URL.Query())
+		if err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, MatchRequest(router.Rules(), req))
+	})
+
+	return mux
+}
+
+// dryRunRequest builds the request described by the parameters of a dry run.
+// The path may include a query string, and method defaults to GET.
+func dryRunRequest(params url.Values) (r *http.Request, err error) {
+	method := params.Get("method")
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := params.Get("path")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	r, err = http.NewRequest(strings.ToUpper(method), path, nil)
+	if err != nil {
+		return
+	}
+	r.Host = params.Get("host")
+
+	for _, header := range params["header"] {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 {
+			err = fmt.Errorf("header %q is not of the form Name:value", header)
+			return
+		}
+		r.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	return
+}
+
+// writeJSON responds with a value encoded as JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,109 @@
+package rsrp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/quells/rsrp"
+)
+
+func TestRouter_AdminHandler(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer backend.Close()
+
+	routes, err := rsrp.ConvertConfig(rsrp.Config{
+		Routes: []rsrp.RouteRuleConfig{
+			{
+				Name:           "orders",
+				Match:          "^/api/orders(/.*)?$",
+				Host:           "api.example.com",
+				Methods:        []string{http.MethodGet, http.MethodPost},
+				Rewrite:        rsrp.RewriteRuleConfig{Input: "^/api(/.*)$", Output: "$1"},
+				Destinations:   []rsrp.DestinationConfig{{URL: backend.URL}, {URL: "http://127.0.0.1:1", Weight: 2}},
+				CircuitBreaker: &rsrp.CircuitBreakerConfig{},
+			},
+			{
+				Match:       "^/.*$",
+				Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+				Destination: backend.URL,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ConvertConfig() unexpected error: %s", err.Error())
+	}
+
+	router := rsrp.NewRouter(*routes)
+	defer router.Close()
+	router.UseMetrics(rsrp.NewMetrics())
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	admin := httptest.NewServer(router.AdminHandler())
+	defer admin.Close()
+
+	routerGet(t, server.URL+"/anything")
+
+	var statuses []rsrp.RouteStatus
+	adminGet(t, admin.URL+"/routes", &statuses)
+	if len(statuses) != 2 {
+		t.Fatalf("AdminHandler() expected 2 routes, got %d", len(statuses))
+	}
+	orders, fallback := statuses[0], statuses[1]
+	if orders.Name != "orders" || orders.Match != "^/api/orders(/.*)?$" || orders.Host == "" || orders.Rewrite.Output != "$1" {
+		t.Fatalf("AdminHandler() expected the named route's compiled patterns, got %+v", orders)
+	}
+	if len(orders.Destinations) != 2 || orders.Destinations[1].Weight != 2 || !orders.Destinations[0].Healthy ||
+		orders.Destinations[0].Breaker == nil || orders.Destinations[0].Breaker.State != rsrp.BreakerClosed {
+		t.Fatalf("AdminHandler() expected destinations with health and breaker state, got %+v", orders.Destinations)
+	}
+	if fallback.Name != "^/.*$" || fallback.Index != 1 {
+		t.Fatalf("AdminHandler() expected an unnamed route to be named by its match, got %+v", fallback)
+	}
+	if fallback.Stats == nil || fallback.Stats.Requests["2xx"] != 1 || orders.Stats == nil || len(orders.Stats.Requests) != 0 {
+		t.Fatalf("AdminHandler() expected live stats, got %+v and %+v", orders.Stats, fallback.Stats)
+	}
+
+	query := url.Values{"method": {"POST"}, "host": {"api.example.com:443"}, "path": {"/api/orders/7"}}
+	var result rsrp.MatchResult
+	adminGet(t, admin.URL+"/match?"+query.Encode(), &result)
+	if result.Route == nil || result.Route.Name != "orders" || result.Path != "/orders/7" ||
+		len(result.Destinations) != 2 || result.Destinations[0] != backend.URL+"/orders/7" {
+		t.Fatalf("AdminHandler() expected a dry run to match the orders route, got %+v", result)
+	}
+
+	query.Set("method", "DELETE")
+	result = rsrp.MatchResult{}
+	adminGet(t, admin.URL+"/match?"+query.Encode(), &result)
+	if result.Route == nil || result.Route.Name != "^/.*$" {
+		t.Fatalf("AdminHandler() expected a DELETE to fall through to the second route, got %+v", result)
+	}
+
+	if status, _ := routerGet(t, admin.URL+"/match?header=invalid"); status != http.StatusBadRequest {
+		t.Fatalf("AdminHandler() expected %d for an invalid header, got %d", http.StatusBadRequest, status)
+	}
+}
+
+func adminGet(t *testing.T, url string, v interface{}) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("AdminHandler() unexpected error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("AdminHandler() returned invalid JSON: %s", err.Error())
+	}
+}
+
+func TestConvertRules_DuplicateName(t *testing.T) {
+	route := rsrp.RouteRuleConfig{Name: "api", Match: "^/.*$", Rewrite: rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"}, Destination: "http://a"}
+	if _, err := rsrp.ConvertRules([]rsrp.RouteRuleConfig{route, route}); err == nil {
+		t.Fatalf("ConvertRules() expected error for duplicate route names")
+	}
+}
@@ -0,0 +1,208 @@
+package rsrp
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// An AuthPolicy requires requests to a route to authenticate with HTTP basic auth or an API key.
+// Users maps user names to bcrypt password hashes, and APIKeys maps the SHA-256 of each key to its user name;
+// API keys are read from the APIKeyHeader.
+// Credentials are removed before requests are proxied, and if UserHeader is set,
+// the authenticated user name is forwarded to the destination in that header.
+type AuthPolicy struct {
+	Users        map[string][]byte
+	APIKeys      map[[sha256.Size]byte]string
+	APIKeyHeader string
+	Realm        string
+	UserHeader   string
+
+	mu       sync.Mutex
+	verified map[string][sha256.Size]byte
+}
+
+// NewAuthPolicy converts an AuthConfig to an AuthPolicy, loading its htpasswd and API key files
+func NewAuthPolicy(config AuthConfig) (policy *AuthPolicy, err error) {
+	if config.HtpasswdFile == "" && config.APIKeysFile == "" {
+		err = fmt.Errorf("authentication requires an htpasswd file or an API keys file")
+		return
+	}
+
+	policy = &AuthPolicy{
+		APIKeyHeader: config.APIKeyHeader,
+		Realm:        config.Realm,
+		UserHeader:   config.UserHeader,
+		verified:     map[string][sha256.Size]byte{},
+	}
+	if policy.APIKeyHeader == "" {
+		policy.APIKeyHeader = "X-Api-Key"
+	}
+	if policy.Realm == "" {
+		policy.Realm = "rsrp"
+	}
+
+	if config.HtpasswdFile != "" {
+		policy.Users, err = LoadHtpasswd(config.HtpasswdFile)
+		if err != nil {
+			return
+		}
+	}
+
+	if config.APIKeysFile != "" {
+		policy.APIKeys, err = LoadAPIKeys(config.APIKeysFile)
+	}
+
+	return
+}
+
+// LoadHtpasswd reads user names and bcrypt password hashes from an htpasswd file.
+// Other hash formats are rejected.
+func LoadHtpasswd(filename string) (users map[string][]byte, err error) {
+	users = map[string][]byte{}
+	err = readCredentials(filename, func(user, hash string) error {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("password for %s in %s is not a bcrypt hash", user, filename)
+		}
+		users[user] = []byte(hash)
+		return nil
+	})
+	return
+}
+
+// LoadAPIKeys reads user names and their API keys from a file of "user:key" lines
+func LoadAPIKeys(filename string) (keys map[[sha256.Size]byte]string, err error) {
+	keys = map[[sha256.Size]byte]string{}
+	err = readCredentials(filename, func(user, key string) error {
+		keys[sha256.Sum256([]byte(key))] = user
+		return nil
+	})
+	return
+}
+
+// readCredentials calls add for each "name:value" line of a file, skipping blank lines and comments
+func readCredentials(filename string, add func(name, value string) error) (err error) {
+	var f *os.File
+	f, err = os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.IndexByte(line, ':')
+		if i <= 0 || i == len(line)-1 {
+			err = fmt.Errorf("invalid line %d in %s", n, filename)
+			return
+		}
+
+		err = add(line[:i], line[i+1:])
+		if err != nil {
+			return
+		}
+	}
+
+	err = scanner.Err()
+	return
+}
+
+// Authenticate returns the user name for the credentials of a request,
+// or an empty string if there are none or they are invalid.
+// An API key takes precedence over basic auth.
+func (policy *AuthPolicy) Authenticate(r *http.Request) string {
+	if policy.APIKeys != nil {
+		if key := r.Header.Get(policy.APIKeyHeader); key != "" {
+			return policy.APIKeys[sha256.Sum256([]byte(key))]
+		}
+	}
+
+	if policy.Users != nil {
+		if user, password, ok := r.BasicAuth(); ok && policy.checkPassword(user, password) {
+			return user
+		}
+	}
+
+	return ""
+}
+
+// checkPassword compares a password with a user's bcrypt hash.
+// bcrypt is deliberately slow, so a digest of the last verified password for each user is remembered.
+func (policy *AuthPolicy) checkPassword(user, password string) bool {
+	hash, ok := policy.Users[user]
+	if !ok {
+		return false
+	}
+
+	digest := sha256.Sum256([]byte(password))
+	policy.mu.Lock()
+	verified, ok := policy.verified[user]
+	policy.mu.Unlock()
+	if ok && hmac.Equal(verified[:], digest[:]) {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+
+	policy.mu.Lock()
+	policy.verified[user] = digest
+	policy.mu.Unlock()
+	return true
+}
+
+type userContextKey struct{}
+
+// AuthenticatedUser returns the user name authenticated by a route's AuthPolicy, or an empty string if there is none
+func AuthenticatedUser(r *http.Request) string {
+	user, _ := r.Context().Value(userContextKey{}).(string)
+	return user
+}
+
+// requireAuth is a Filter which rejects requests without valid credentials for the RouteRule's Auth with 401
+func (rule RouteRule) requireAuth(w http.ResponseWriter, r *http.Request) *http.Request {
+	user := rule.Auth.Authenticate(r)
+	if user == "" {
+		if rule.Auth.Users != nil {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", rule.Auth.Realm))
+		}
+		httpError(w, r, "authentication required for "+r.URL.Path, http.StatusUnauthorized)
+		return nil
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+}
+
+// stripCredentials is a RequestModifier which removes basic auth and API key credentials from the proxied request
+// and, if the RouteRule's Auth has a UserHeader, passes the authenticated user name to the destination
+func (rule RouteRule) stripCredentials(out, in *http.Request) {
+	if rule.Auth.Users != nil {
+		if auth := out.Header.Get("Authorization"); len(auth) >= len("Basic ") && strings.EqualFold(auth[:len("Basic ")], "Basic ") {
+			out.Header.Del("Authorization")
+		}
+	}
+	if rule.Auth.APIKeys != nil {
+		out.Header.Del(rule.Auth.APIKeyHeader)
+	}
+
+	if rule.Auth.UserHeader != "" {
+		out.Header.Del(rule.Auth.UserHeader)
+		if user := AuthenticatedUser(in); user != "" {
+			out.Header.Set(rule.Auth.UserHeader, user)
+		}
+	}
+}
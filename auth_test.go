@@ -0,0 +1,107 @@
+package rsrp_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/quells/rsrp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRouteRule_Auth(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	htpasswd := filepath.Join(dir, "htpasswd")
+	ioutil.WriteFile(htpasswd, []byte("# users\nalice:"+string(hash)+"\n"), 0600)
+	apiKeys := filepath.Join(dir, "api-keys")
+	ioutil.WriteFile(apiKeys, []byte("ci:k3y:with:colons\n\n"), 0600)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Join([]string{r.Header.Get("X-Auth-User"), r.Header.Get("Authorization"), r.Header.Get("X-Api-Key")}, "|")))
+	}))
+	defer backend.Close()
+
+	routes, err := rsrp.ConvertRules([]rsrp.RouteRuleConfig{{
+		Match:       "^/tools$",
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+		Destination: backend.URL,
+		Auth: &rsrp.AuthConfig{
+			HtpasswdFile: htpasswd,
+			APIKeysFile:  apiKeys,
+			Realm:        "tools",
+			UserHeader:   "X-Auth-User",
+		},
+	}})
+	if err != nil {
+		t.Fatalf("ConvertRules() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll(*routes)))
+	defer server.Close()
+
+	get := func(setCredentials func(*http.Request)) (int, string, string) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/tools", nil)
+		req.Header.Set("X-Auth-User", "spoofed")
+		setCredentials(req)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, resp.Header.Get("WWW-Authenticate"), string(body)
+	}
+
+	status, challenge, _ := get(func(r *http.Request) {})
+	if status != http.StatusUnauthorized || challenge != `Basic realm="tools", charset="UTF-8"` {
+		t.Fatalf("RouteAll() expected 401 with a basic auth challenge, got %d %q", status, challenge)
+	}
+	if status, _, _ := get(func(r *http.Request) { r.SetBasicAuth("alice", "wrong") }); status != http.StatusUnauthorized {
+		t.Fatalf("RouteAll() expected 401 for a wrong password, got %d", status)
+	}
+	if status, _, _ := get(func(r *http.Request) { r.Header.Set("X-Api-Key", "k3y") }); status != http.StatusUnauthorized {
+		t.Fatalf("RouteAll() expected 401 for an unknown API key, got %d", status)
+	}
+
+	// Twice, to use the remembered password
+	for i := 0; i < 2; i++ {
+		status, _, body := get(func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") })
+		if status != http.StatusOK || body != "alice||" {
+			t.Fatalf("RouteAll() expected the user to be forwarded without credentials, got %d %q", status, body)
+		}
+	}
+
+	status, _, body := get(func(r *http.Request) { r.Header.Set("X-Api-Key", "k3y:with:colons") })
+	if status != http.StatusOK || body != "ci||" {
+		t.Fatalf("RouteAll() expected the API key's user to be forwarded without the key, got %d %q", status, body)
+	}
+}
+
+func TestNewAuthPolicy(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+
+	sha := filepath.Join(dir, "sha")
+	ioutil.WriteFile(sha, []byte("alice:{SHA}9Hk8r9fqRZo1gSJuf8WJU4D9ot0=\n"), 0600)
+	malformed := filepath.Join(dir, "malformed")
+	ioutil.WriteFile(malformed, []byte("no separator\n"), 0600)
+
+	invalid := []rsrp.AuthConfig{
+		{},
+		{HtpasswdFile: sha},
+		{APIKeysFile: malformed},
+		{HtpasswdFile: filepath.Join(dir, "missing")},
+	}
+	for _, config := range invalid {
+		if _, err := rsrp.NewAuthPolicy(config); err == nil {
+			t.Fatalf("NewAuthPolicy() expected error for %+v", config)
+		}
+	}
+}
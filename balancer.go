@@ -0,0 +1,373 @@
+package rsrp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A Destination is a single upstream server/service for a route.
+// If Breaker is set, the Destination is taken out of rotation while it is open.
+// If TLS is set, it is used to connect to the Destination, through Client for HTTP requests.
+type Destination struct {
+	URL     string
+	Weight  int
+	Breaker *CircuitBreaker
+	TLS     *tls.Config
+	Client  *http.Client
+
+	outstanding int64
+	unhealthy   int32
+}
+
+// NewDestination converts a DestinationConfig to a Destination
+func NewDestination(config DestinationConfig) (destination *Destination, err error) {
+	weight := config.Weight
+	if weight < 0 {
+		err = fmt.Errorf("negative weight %d for destination %s", weight, config.URL)
+		return
+	}
+	if weight == 0 {
+		weight = 1
+	}
+
+	destination = &Destination{
+		URL:    config.URL,
+		Weight: weight,
+	}
+
+	if config.TLS != nil {
+		destination.TLS, err = NewUpstreamTLSConfig(*config.TLS)
+		if err != nil {
+			return
+		}
+		destination.Client = NewTLSClient(TransportConfig{}, destination.TLS)
+	}
+
+	return
+}
+
+// Outstanding returns the number of requests currently in flight to the Destination
+func (d *Destination) Outstanding() int64 {
+	return atomic.LoadInt64(&d.outstanding)
+}
+
+func (d *Destination) acquire() {
+	atomic.AddInt64(&d.outstanding, 1)
+}
+
+func (d *Destination) release() {
+	atomic.AddInt64(&d.outstanding, -1)
+}
+
+// available reports whether a Destination is healthy and its CircuitBreaker would allow a request
+func (d *Destination) available() bool {
+	return d.Healthy() && d.Breaker.Ready()
+}
+
+// dialer returns the websocket.Dialer for WebSocket connections to the Destination, or nil for the default
+func (d *Destination) dialer() *websocket.Dialer {
+	if d.TLS == nil {
+		return nil
+	}
+
+	// WebSocket upgrades need HTTP/1.1, whatever the Destination's HTTP client negotiates
+	tlsConfig := d.TLS.Clone()
+	tlsConfig.NextProtos = []string{"http/1.1"}
+
+	return &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 45 * time.Second,
+		TLSClientConfig:  tlsConfig,
+	}
+}
+
+func (d *Destination) weight() int {
+	if d.Weight <= 0 {
+		return 1
+	}
+	return d.Weight
+}
+
+// A Strategy chooses which of the candidate Destinations receives a request
+type Strategy interface {
+	Choose(r *http.Request, candidates []*Destination) *Destination
+}
+
+// A Balancer distributes requests for a route across its healthy Destinations
+type Balancer struct {
+	Destinations []*Destination
+	Strategy     Strategy
+	HealthCheck  *HealthCheck
+
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// NewBalancer converts DestinationConfigs and a BalanceConfig to a Balancer
+func NewBalancer(destinations []DestinationConfig, config BalanceConfig) (balancer *Balancer, err error) {
+	var strategy Strategy
+	strategy, err = NewStrategy(config)
+	if err != nil {
+		return
+	}
+
+	balancer = &Balancer{
+		Destinations: make([]*Destination, len(destinations)),
+		Strategy:     strategy,
+	}
+
+	for i, d := range destinations {
+		balancer.Destinations[i], err = NewDestination(d)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Next chooses the Destination for a request, or nil if there are none available.
+// The request may be nil, in which case request-dependent strategies fall back to weighted random.
+func (b *Balancer) Next(r *http.Request) *Destination {
+	candidates := b.available()
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		return candidates[0]
+	}
+
+	return b.Strategy.Choose(r, candidates)
+}
+
+// NextExcluding chooses the Destination for a retried request, preferring Destinations which have not been tried.
+// If every available Destination has been tried, any of them may be chosen.
+func (b *Balancer) NextExcluding(r *http.Request, tried []*Destination) *Destination {
+	if d := b.nextUntried(r, tried); d != nil {
+		return d
+	}
+
+	return b.Next(r)
+}
+
+// nextUntried chooses an available Destination which has not been tried, or nil if there are none
+func (b *Balancer) nextUntried(r *http.Request, tried []*Destination) *Destination {
+	available := b.available()
+
+	candidates := make([]*Destination, 0, len(available))
+	for _, d := range available {
+		if !containsDestination(tried, d) {
+			candidates = append(candidates, d)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		return candidates[0]
+	}
+
+	return b.Strategy.Choose(r, candidates)
+}
+
+func containsDestination(destinations []*Destination, d *Destination) bool {
+	for _, candidate := range destinations {
+		if candidate == d {
+			return true
+		}
+	}
+	return false
+}
+
+// available returns the Destinations which are currently in rotation
+func (b *Balancer) available() []*Destination {
+	for i, d := range b.Destinations {
+		if d.available() {
+			continue
+		}
+
+		// Only allocate when at least one Destination is out of rotation
+		candidates := make([]*Destination, i, len(b.Destinations))
+		copy(candidates, b.Destinations[:i])
+		for _, d := range b.Destinations[i+1:] {
+			if d.available() {
+				candidates = append(candidates, d)
+			}
+		}
+		return candidates
+	}
+
+	return b.Destinations
+}
+
+// NewStrategy converts a BalanceConfig to a Strategy
+func NewStrategy(config BalanceConfig) (strategy Strategy, err error) {
+	switch config.Strategy {
+	case "", "round-robin":
+		strategy = &RoundRobin{}
+	case "weighted-random":
+		strategy = WeightedRandom{}
+	case "least-outstanding":
+		strategy = LeastOutstanding{}
+	case "consistent-hash":
+		if config.Header == "" && config.Cookie == "" {
+			err = fmt.Errorf("consistent-hash strategy requires a header or cookie")
+			return
+		}
+		strategy = ConsistentHash{
+			Header: config.Header,
+			Cookie: config.Cookie,
+		}
+	default:
+		err = fmt.Errorf("unknown balance strategy %q", config.Strategy)
+	}
+
+	return
+}
+
+// RoundRobin cycles through Destinations in proportion to their weights.
+// It uses the smooth weighted round-robin algorithm so that heavier
+// Destinations are interleaved with lighter ones rather than chosen in bursts.
+type RoundRobin struct {
+	mu      sync.Mutex
+	current map[*Destination]int
+}
+
+// Choose conforms RoundRobin to Strategy
+func (s *RoundRobin) Choose(r *http.Request, candidates []*Destination) *Destination {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil {
+		s.current = make(map[*Destination]int)
+	}
+
+	var best *Destination
+	total := 0
+	for _, d := range candidates {
+		w := d.weight()
+		total += w
+		s.current[d] += w
+		if best == nil || s.current[d] > s.current[best] {
+			best = d
+		}
+	}
+	s.current[best] -= total
+
+	return best
+}
+
+// WeightedRandom chooses Destinations at random in proportion to their weights
+type WeightedRandom struct{}
+
+// Choose conforms WeightedRandom to Strategy
+func (WeightedRandom) Choose(r *http.Request, candidates []*Destination) *Destination {
+	total := 0
+	for _, d := range candidates {
+		total += d.weight()
+	}
+
+	n := rand.Intn(total)
+	for _, d := range candidates {
+		n -= d.weight()
+		if n < 0 {
+			return d
+		}
+	}
+
+	return candidates[len(candidates)-1]
+}
+
+// LeastOutstanding chooses the Destination with the fewest in-flight requests relative to its weight.
+// Ties are broken at random.
+type LeastOutstanding struct{}
+
+// Choose conforms LeastOutstanding to Strategy
+func (LeastOutstanding) Choose(r *http.Request, candidates []*Destination) *Destination {
+	var best *Destination
+	var bestLoad float64
+	ties := 0
+	for _, d := range candidates {
+		load := float64(d.Outstanding()) / float64(d.weight())
+		switch {
+		case best == nil || load < bestLoad:
+			best, bestLoad, ties = d, load, 1
+		case load == bestLoad:
+			ties++
+			if rand.Intn(ties) == 0 {
+				best = d
+			}
+		}
+	}
+
+	return best
+}
+
+// ConsistentHash chooses a Destination based on the value of a request header or cookie,
+// so that requests with the same value go to the same Destination while it is available.
+// It uses weighted rendezvous hashing, which only remaps the keys of a Destination
+// when that Destination is removed from the candidates.
+// Requests without the header or cookie are distributed by weighted random.
+type ConsistentHash struct {
+	Header string
+	Cookie string
+}
+
+// Choose conforms ConsistentHash to Strategy
+func (s ConsistentHash) Choose(r *http.Request, candidates []*Destination) *Destination {
+	key, ok := s.key(r)
+	if !ok {
+		return WeightedRandom{}.Choose(r, candidates)
+	}
+
+	var best *Destination
+	bestScore := math.Inf(-1)
+	for _, d := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(d.URL))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+
+		// Map the hash onto (0, 1) and weight it so that each Destination
+		// wins a share of keys proportional to its weight.
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		score := -float64(d.weight()) / math.Log(u)
+		if score > bestScore {
+			best, bestScore = d, score
+		}
+	}
+
+	return best
+}
+
+func (s ConsistentHash) key(r *http.Request) (key string, ok bool) {
+	if r == nil {
+		return
+	}
+
+	if s.Header != "" {
+		key = r.Header.Get(s.Header)
+		if key != "" {
+			ok = true
+			return
+		}
+	}
+
+	if s.Cookie != "" {
+		if c, err := r.Cookie(s.Cookie); err == nil && c.Value != "" {
+			key, ok = c.Value, true
+		}
+	}
+
+	return
+}
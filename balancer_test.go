@@ -0,0 +1,186 @@
+package rsrp_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/quells/rsrp"
+)
+
+func TestRoundRobin_Choose(t *testing.T) {
+	a := &rsrp.Destination{URL: "http://a", Weight: 2}
+	b := &rsrp.Destination{URL: "http://b", Weight: 1}
+	balancer := &rsrp.Balancer{
+		Destinations: []*rsrp.Destination{a, b},
+		Strategy:     &rsrp.RoundRobin{},
+	}
+
+	var order string
+	for i := 0; i < 6; i++ {
+		order += balancer.Next(nil).URL[len("http://"):]
+	}
+
+	expected := "abaaba"
+	if order != expected {
+		t.Fatalf("RoundRobin.Choose() expected order %s, got %s", expected, order)
+	}
+}
+
+func TestLeastOutstanding_Choose(t *testing.T) {
+	block := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer backend.Close()
+
+	rule, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match: "^/$",
+		Destinations: []rsrp.DestinationConfig{
+			{URL: backend.URL},
+			{URL: backend.URL + "/"},
+		},
+		Balance: rsrp.BalanceConfig{Strategy: "least-outstanding"},
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+	defer close(block)
+
+	// Hold one request open so that one destination is busy
+	go http.Get(server.URL + "/")
+
+	var busy *rsrp.Destination
+	deadline := time.Now().Add(5 * time.Second)
+	for busy == nil && time.Now().Before(deadline) {
+		for _, d := range rule.Balancer.Destinations {
+			if d.Outstanding() == 1 {
+				busy = d
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if busy == nil {
+		t.Fatalf("LeastOutstanding.Choose() expected a destination with an outstanding request")
+	}
+
+	for i := 0; i < 10; i++ {
+		if d := rule.Balancer.Next(nil); d == busy {
+			t.Fatalf("LeastOutstanding.Choose() expected to avoid %s with outstanding requests", busy.URL)
+		}
+	}
+}
+
+func TestConsistentHash_Choose(t *testing.T) {
+	balancer, err := rsrp.NewBalancer([]rsrp.DestinationConfig{
+		{URL: "http://a"},
+		{URL: "http://b"},
+		{URL: "http://c"},
+	}, rsrp.BalanceConfig{Strategy: "consistent-hash", Header: "X-User"})
+	if err != nil {
+		t.Fatalf("NewBalancer() unexpected error: %s", err.Error())
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User", fmt.Sprintf("user-%d", i))
+
+		first := balancer.Next(r)
+		for j := 0; j < 5; j++ {
+			if d := balancer.Next(r); d != first {
+				t.Fatalf("ConsistentHash.Choose() expected user-%d to stick to %s, got %s", i, first.URL, d.URL)
+			}
+		}
+		seen[first.URL] = true
+	}
+
+	if len(seen) < 2 {
+		t.Fatalf("ConsistentHash.Choose() expected keys to spread across destinations, got %v", seen)
+	}
+}
+
+func TestNewBalancer_Errors(t *testing.T) {
+	testCases := []struct {
+		destinations []rsrp.DestinationConfig
+		balance      rsrp.BalanceConfig
+	}{
+		{nil, rsrp.BalanceConfig{Strategy: "fastest"}},
+		{nil, rsrp.BalanceConfig{Strategy: "consistent-hash"}},
+		{[]rsrp.DestinationConfig{{URL: "http://a", Weight: -1}}, rsrp.BalanceConfig{}},
+	}
+
+	for _, tc := range testCases {
+		if _, err := rsrp.NewBalancer(tc.destinations, tc.balance); err == nil {
+			t.Fatalf("NewBalancer() expected error for %+v", tc)
+		}
+	}
+}
+
+func TestRouteAll_Balanced(t *testing.T) {
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("A"))
+	}))
+	defer serverA.Close()
+
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("B"))
+	}))
+	defer serverB.Close()
+
+	configStr := fmt.Sprintf(`
+	{
+		"routes": [
+			{
+				"match": "^\/.*$",
+				"rewrite": {
+					"from": "^(\/.*)$",
+					"to": "$1"
+				},
+				"destinations": [
+					{"url": "%s", "weight": 3},
+					{"url": "%s", "weight": 1}
+				],
+				"balance": {
+					"strategy": "round-robin"
+				}
+			}
+		]
+	}
+	`, serverA.URL, serverB.URL)
+	config := &rsrp.Config{}
+	_ = json.Unmarshal([]byte(configStr), config)
+
+	routes, err := rsrp.ConvertRules(config.Routes)
+	if err != nil {
+		t.Fatalf("ConvertRules() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll(*routes)))
+	defer server.Close()
+
+	client := server.Client()
+
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		resp, err := client.Get(server.URL + "/test")
+		if err != nil {
+			t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+		}
+
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		counts[string(data)]++
+	}
+
+	if counts["A"] != 6 || counts["B"] != 2 {
+		t.Fatalf("RouteAll() expected 6 requests to A and 2 to B, got %v", counts)
+	}
+}
@@ -0,0 +1,352 @@
+package rsrp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A BreakerState is the state of a CircuitBreaker
+type BreakerState int
+
+// A closed CircuitBreaker lets requests through, an open one rejects them,
+// and a half-open one lets a limited number through to test whether the Destination has recovered.
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalJSON conforms BreakerState to json.Marshaler
+func (s BreakerState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON conforms BreakerState to json.Unmarshaler
+func (s *BreakerState) UnmarshalJSON(data []byte) (err error) {
+	var name string
+	err = json.Unmarshal(data, &name)
+	if err != nil {
+		return
+	}
+
+	for _, state := range []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen} {
+		if state.String() == name {
+			*s = state
+			return
+		}
+	}
+
+	return fmt.Errorf("unknown circuit breaker state %q", name)
+}
+
+// A CircuitBreakerPolicy describes when the CircuitBreakers of a route's Destinations trip,
+// and the response sent while they are open.
+// A breaker opens after ConsecutiveFailures failures in a row, or when at least FailureRatio
+// of the requests in a Window have failed, once there have been MinimumRequests.
+// After CoolDown it lets HalfOpenRequests requests through, closing again if they all succeed.
+type CircuitBreakerPolicy struct {
+	ConsecutiveFailures int
+	FailureRatio        float64
+	MinimumRequests     int
+	Window              time.Duration
+	CoolDown            time.Duration
+	HalfOpenRequests    int
+
+	Status      int
+	ContentType string
+	Body        string
+}
+
+// NewCircuitBreakerPolicy converts a CircuitBreakerConfig to a CircuitBreakerPolicy, filling in defaults
+func NewCircuitBreakerPolicy(config CircuitBreakerConfig) (policy *CircuitBreakerPolicy, err error) {
+	if config.FailureRatio < 0 || config.FailureRatio > 1 {
+		err = fmt.Errorf("circuit breaker failure ratio %v is not between 0 and 1", config.FailureRatio)
+		return
+	}
+	if config.ConsecutiveFailures < 0 {
+		err = fmt.Errorf("negative circuit breaker consecutive failures %d", config.ConsecutiveFailures)
+		return
+	}
+
+	policy = &CircuitBreakerPolicy{
+		ConsecutiveFailures: config.ConsecutiveFailures,
+		FailureRatio:        config.FailureRatio,
+		MinimumRequests:     intOr(config.MinimumRequests, 10),
+		Window:              durationOr(config.Window, 10*time.Second),
+		CoolDown:            durationOr(config.CoolDown, 30*time.Second),
+		HalfOpenRequests:    intOr(config.HalfOpenRequests, 1),
+		Status:              intOr(config.Response.Status, http.StatusServiceUnavailable),
+		ContentType:         config.Response.ContentType,
+		Body:                config.Response.Body,
+	}
+
+	if policy.ConsecutiveFailures == 0 && policy.FailureRatio == 0 {
+		policy.ConsecutiveFailures = 5
+	}
+
+	return
+}
+
+// reject responds to a request which was short-circuited by an open CircuitBreaker
+func (policy *CircuitBreakerPolicy) reject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(policy.CoolDown)))
+
+	if policy.Body == "" {
+		httpError(w, r, "circuit open for "+r.URL.Path, policy.Status)
+		return
+	}
+
+	contentType := policy.ContentType
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(policy.Status)
+	w.Write([]byte(policy.Body))
+}
+
+// A CircuitBreaker tracks the failures of a single Destination.
+// A nil *CircuitBreaker always allows requests.
+type CircuitBreaker struct {
+	Policy *CircuitBreakerPolicy
+
+	mu          sync.Mutex
+	state       BreakerState
+	requests    int
+	failures    int
+	consecutive int
+	windowStart time.Time
+	openedAt    time.Time
+	probes      int
+	successes   int
+}
+
+// NewCircuitBreaker creates a closed CircuitBreaker
+func NewCircuitBreaker(policy *CircuitBreakerPolicy) *CircuitBreaker {
+	return &CircuitBreaker{Policy: policy}
+}
+
+// State returns the current state of the CircuitBreaker
+func (cb *CircuitBreaker) State() BreakerState {
+	if cb == nil {
+		return BreakerClosed
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == BreakerOpen && time.Since(cb.openedAt) >= cb.Policy.CoolDown {
+		return BreakerHalfOpen
+	}
+	return cb.state
+}
+
+// Open reports whether the CircuitBreaker is rejecting every request
+func (cb *CircuitBreaker) Open() bool {
+	return cb.State() == BreakerOpen
+}
+
+// Ready reports whether Allow would let a request through, without reserving a half-open probe
+func (cb *CircuitBreaker) Ready() bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		return time.Since(cb.openedAt) >= cb.Policy.CoolDown
+	case BreakerHalfOpen:
+		return cb.probes < cb.Policy.HalfOpenRequests
+	default:
+		return true
+	}
+}
+
+// Allow reports whether a request may be sent to the Destination.
+// Every allowed request must be followed by a call to Record or Cancel.
+func (cb *CircuitBreaker) Allow() bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == BreakerOpen {
+		if time.Since(cb.openedAt) < cb.Policy.CoolDown {
+			return false
+		}
+		cb.state = BreakerHalfOpen
+		cb.probes, cb.successes = 0, 0
+	}
+
+	if cb.state == BreakerHalfOpen {
+		if cb.probes >= cb.Policy.HalfOpenRequests {
+			return false
+		}
+		cb.probes++
+	}
+
+	return true
+}
+
+// Record updates the CircuitBreaker with the outcome of an allowed request
+func (cb *CircuitBreaker) Record(success bool) {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerHalfOpen:
+		if !success {
+			cb.trip()
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.Policy.HalfOpenRequests {
+			cb.reset(BreakerClosed)
+		}
+
+	case BreakerClosed:
+		if time.Since(cb.windowStart) >= cb.Policy.Window {
+			cb.windowStart = time.Now()
+			cb.requests, cb.failures = 0, 0
+		}
+
+		cb.requests++
+		if success {
+			cb.consecutive = 0
+		} else {
+			cb.failures++
+			cb.consecutive++
+		}
+
+		policy := cb.Policy
+		if policy.ConsecutiveFailures > 0 && cb.consecutive >= policy.ConsecutiveFailures {
+			cb.trip()
+			return
+		}
+		if policy.FailureRatio > 0 && cb.requests >= policy.MinimumRequests &&
+			float64(cb.failures) >= policy.FailureRatio*float64(cb.requests) {
+			cb.trip()
+		}
+	}
+}
+
+// Cancel gives up an allowed request without recording an outcome, such as when the client went away,
+// so that a half-open CircuitBreaker may send another probe
+func (cb *CircuitBreaker) Cancel() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == BreakerHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+func (cb *CircuitBreaker) trip() {
+	cb.reset(BreakerOpen)
+	cb.openedAt = time.Now()
+}
+
+func (cb *CircuitBreaker) reset(state BreakerState) {
+	cb.state = state
+	cb.requests, cb.failures, cb.consecutive = 0, 0, 0
+	cb.probes, cb.successes = 0, 0
+	cb.windowStart = time.Now()
+}
+
+// A CircuitBreakerStatus describes the CircuitBreaker of one Destination of a route
+type CircuitBreakerStatus struct {
+	Route       string       `json:"route"`
+	Destination string       `json:"destination"`
+	State       BreakerState `json:"state"`
+	Failures    int          `json:"failures"`
+	Requests    int          `json:"requests"`
+	OpenedAt    *time.Time   `json:"openedAt,omitempty"`
+}
+
+// Status returns a snapshot of the CircuitBreaker
+func (cb *CircuitBreaker) Status() (status CircuitBreakerStatus) {
+	status.State = cb.State()
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	status.Failures = cb.failures
+	status.Requests = cb.requests
+	if status.State != BreakerClosed {
+		openedAt := cb.openedAt
+		status.OpenedAt = &openedAt
+	}
+
+	return
+}
+
+// CircuitBreakerStatuses returns the status of every Destination's CircuitBreaker, in route order
+func CircuitBreakerStatuses(rules []RouteRule) (statuses []CircuitBreakerStatus) {
+	statuses = []CircuitBreakerStatus{}
+	for _, rule := range rules {
+		if rule.Balancer == nil {
+			continue
+		}
+
+		for _, d := range rule.Balancer.Destinations {
+			if d.Breaker == nil {
+				continue
+			}
+
+			status := d.Breaker.Status()
+			status.Route = rule.name()
+			status.Destination = d.URL
+			statuses = append(statuses, status)
+		}
+	}
+
+	return
+}
+
+// circuitOpen reports whether any of the RouteRule's Destinations are refusing requests
+func (rule RouteRule) circuitOpen() bool {
+	if rule.CircuitBreaker == nil || rule.Balancer == nil {
+		return false
+	}
+
+	for _, d := range rule.Balancer.Destinations {
+		if !d.Breaker.Ready() {
+			return true
+		}
+	}
+
+	return false
+}
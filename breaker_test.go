@@ -0,0 +1,215 @@
+package rsrp_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quells/rsrp"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	policy, _ := rsrp.NewCircuitBreakerPolicy(rsrp.CircuitBreakerConfig{
+		ConsecutiveFailures: 2,
+		CoolDown:            rsrp.Duration(20 * time.Millisecond),
+	})
+	cb := rsrp.NewCircuitBreaker(policy)
+
+	cb.Allow()
+	cb.Record(false)
+	cb.Allow()
+	cb.Record(true)
+	cb.Allow()
+	cb.Record(false)
+	if cb.State() != rsrp.BreakerClosed {
+		t.Fatalf("CircuitBreaker expected to stay closed without consecutive failures, got %s", cb.State())
+	}
+
+	cb.Allow()
+	cb.Record(false)
+	if cb.State() != rsrp.BreakerOpen || cb.Allow() {
+		t.Fatalf("CircuitBreaker expected to open after 2 consecutive failures, got %s", cb.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !cb.Allow() || cb.Allow() {
+		t.Fatalf("CircuitBreaker expected to allow a single request when half-open")
+	}
+	cb.Record(false)
+	if cb.State() != rsrp.BreakerOpen {
+		t.Fatalf("CircuitBreaker expected to reopen after a failed probe, got %s", cb.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	cb.Allow()
+	cb.Record(true)
+	if cb.State() != rsrp.BreakerClosed {
+		t.Fatalf("CircuitBreaker expected to close after a successful probe, got %s", cb.State())
+	}
+}
+
+func TestCircuitBreaker_FailureRatio(t *testing.T) {
+	policy, _ := rsrp.NewCircuitBreakerPolicy(rsrp.CircuitBreakerConfig{
+		FailureRatio:    0.5,
+		MinimumRequests: 4,
+	})
+	cb := rsrp.NewCircuitBreaker(policy)
+
+	for _, success := range []bool{false, true, false} {
+		cb.Allow()
+		cb.Record(success)
+	}
+	if cb.State() != rsrp.BreakerClosed {
+		t.Fatalf("CircuitBreaker expected to stay closed below the minimum requests, got %s", cb.State())
+	}
+
+	cb.Allow()
+	cb.Record(true)
+	if cb.State() != rsrp.BreakerOpen {
+		t.Fatalf("CircuitBreaker expected to open at a 50%% failure ratio, got %s", cb.State())
+	}
+
+	if _, err := rsrp.NewCircuitBreakerPolicy(rsrp.CircuitBreakerConfig{FailureRatio: 1.5}); err == nil {
+		t.Fatalf("NewCircuitBreakerPolicy() expected error for a ratio above 1")
+	}
+}
+
+func TestRouteRule_CircuitBreaker(t *testing.T) {
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+
+	routes, err := rsrp.ConvertRules([]rsrp.RouteRuleConfig{{
+		Match:       "^/.*$",
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+		Destination: backend.URL,
+		CircuitBreaker: &rsrp.CircuitBreakerConfig{
+			ConsecutiveFailures: 2,
+			CoolDown:            rsrp.Duration(time.Minute),
+			Response:            rsrp.CircuitBreakerResponseConfig{Status: http.StatusServiceUnavailable, ContentType: "application/json", Body: `{"error":"unavailable"}`},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("ConvertRules() unexpected error: %s", err.Error())
+	}
+
+	router := rsrp.NewRouter(*routes)
+	defer router.Close()
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		if status, _ := routerGet(t, server.URL+"/item"); status != http.StatusInternalServerError {
+			t.Fatalf("Router expected the destination's 500, got %d", status)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/item")
+	if err != nil {
+		t.Fatalf("Router unexpected error: %s", err.Error())
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || string(body) != `{"error":"unavailable"}` || resp.Header.Get("Retry-After") != "60" {
+		t.Fatalf("Router expected the configured open circuit response, got %d %q", resp.StatusCode, body)
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("Router expected the open circuit to stop requests reaching the destination, got %d calls", calls)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.CircuitBreakerHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/breakers", nil))
+
+	var statuses []map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("CircuitBreakerHandler() expected JSON, got %q", recorder.Body.String())
+	}
+	if len(statuses) != 1 || statuses[0]["state"] != "open" || statuses[0]["destination"] != backend.URL {
+		t.Fatalf("CircuitBreakerHandler() expected one open breaker, got %v", statuses)
+	}
+}
+
+func TestRouteRule_CircuitBreakerSkipsDestination(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer working.Close()
+
+	rule, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:          "^/.*$",
+		Rewrite:        rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+		Destinations:   []rsrp.DestinationConfig{{URL: failing.URL}, {URL: working.URL}},
+		CircuitBreaker: &rsrp.CircuitBreakerConfig{ConsecutiveFailures: 1, CoolDown: rsrp.Duration(time.Minute)},
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	failures := 0
+	for i := 0; i < 10; i++ {
+		if status, _ := routerGet(t, server.URL+"/item"); status != http.StatusOK {
+			failures++
+		}
+	}
+
+	if failures != 1 || !rule.Balancer.Destinations[0].Breaker.Open() {
+		t.Fatalf("RouteAll() expected the failing destination to be skipped after one failure, got %d failures", failures)
+	}
+}
+
+func TestRouteRule_CircuitBreakerHalfOpen(t *testing.T) {
+	recovering := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer recovering.Close()
+
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer working.Close()
+
+	rule, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:          "^/.*$",
+		Rewrite:        rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+		Destinations:   []rsrp.DestinationConfig{{URL: recovering.URL}, {URL: working.URL}},
+		CircuitBreaker: &rsrp.CircuitBreakerConfig{ConsecutiveFailures: 1, CoolDown: rsrp.Duration(10 * time.Millisecond)},
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+
+	breaker := rule.Balancer.Destinations[0].Breaker
+	breaker.Allow()
+	breaker.Record(false)
+	time.Sleep(20 * time.Millisecond)
+
+	// Take the only half-open probe, as a request still in flight would
+	if !breaker.Allow() || breaker.Ready() {
+		t.Fatalf("CircuitBreaker expected to allow a single probe when half-open")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	for i := 0; i < 10; i++ {
+		if status, _ := routerGet(t, server.URL+"/item"); status != http.StatusOK {
+			t.Fatalf("RouteAll() expected requests to go to the healthy destination while a probe is in flight, got %d", status)
+		}
+	}
+
+	breaker.Cancel()
+	if breaker.State() != rsrp.BreakerHalfOpen || !breaker.Ready() {
+		t.Fatalf("CircuitBreaker expected a cancelled probe to free its slot without closing, got %s", breaker.State())
+	}
+}
@@ -0,0 +1,163 @@
+package rsrp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+)
+
+// clientAuthTypes maps the values accepted in TLSConfig.ClientAuth
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"optional": tls.VerifyClientCertIfGiven,
+	"required": tls.RequireAndVerifyClientCert,
+}
+
+// setClientAuth configures a listener's *tls.Config to verify client certificates against a CA bundle
+func setClientAuth(tlsConfig *tls.Config, config TLSConfig) (err error) {
+	if config.ClientCAFile == "" {
+		if config.ClientAuth != "" {
+			err = fmt.Errorf("client authentication %q requires a client CA file", config.ClientAuth)
+		}
+		return
+	}
+
+	var pem []byte
+	pem, err = ioutil.ReadFile(config.ClientCAFile)
+	if err != nil {
+		return
+	}
+
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+		err = fmt.Errorf("no certificates found in client CA bundle %s", config.ClientCAFile)
+		return
+	}
+
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if config.ClientAuth != "" {
+		var ok bool
+		tlsConfig.ClientAuth, ok = clientAuthTypes[config.ClientAuth]
+		if !ok {
+			err = fmt.Errorf("unknown client authentication %q", config.ClientAuth)
+		}
+	}
+
+	return
+}
+
+// A ClientCertPolicy requires requests to a route to present a verified client certificate.
+// Each non-empty list must have a pattern matching the certificate: Subjects and Issuers are tested
+// against the distinguished name, such as "CN=billing,O=Example", and SANs against each DNS name,
+// email address, URI and IP address.
+// If Header is set, the verified subject is forwarded to the destination in that header,
+// replacing any value sent by the client.
+type ClientCertPolicy struct {
+	Subjects []*regexp.Regexp
+	SANs     []*regexp.Regexp
+	Issuers  []*regexp.Regexp
+	Header   string
+}
+
+// NewClientCertPolicy converts a ClientCertConfig to a ClientCertPolicy
+func NewClientCertPolicy(config ClientCertConfig) (policy *ClientCertPolicy, err error) {
+	policy = &ClientCertPolicy{Header: config.Header}
+
+	policy.Subjects, err = compilePatterns(config.Subjects)
+	if err != nil {
+		return
+	}
+	policy.SANs, err = compilePatterns(config.SANs)
+	if err != nil {
+		return
+	}
+	policy.Issuers, err = compilePatterns(config.Issuers)
+	return
+}
+
+func compilePatterns(patterns []string) (compiled []*regexp.Regexp, err error) {
+	for _, pattern := range patterns {
+		var re *regexp.Regexp
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			return
+		}
+		compiled = append(compiled, re)
+	}
+
+	return
+}
+
+func matchesAny(patterns []*regexp.Regexp, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if pattern.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Allows reports whether a verified client certificate satisfies the ClientCertPolicy
+func (policy *ClientCertPolicy) Allows(cert *x509.Certificate) bool {
+	if len(policy.Subjects) > 0 && !matchesAny(policy.Subjects, cert.Subject.String()) {
+		return false
+	}
+
+	if len(policy.Issuers) > 0 && !matchesAny(policy.Issuers, cert.Issuer.String()) {
+		return false
+	}
+
+	if len(policy.SANs) > 0 {
+		sans := append(append([]string(nil), cert.DNSNames...), cert.EmailAddresses...)
+		for _, uri := range cert.URIs {
+			sans = append(sans, uri.String())
+		}
+		for _, ip := range cert.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+
+		if !matchesAny(policy.SANs, sans...) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// clientCertificate returns the verified client certificate of a request, or nil if there is none
+func clientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+
+	return r.TLS.PeerCertificates[0]
+}
+
+// requireClientCert is a Filter which rejects requests without a client certificate allowed by the RouteRule's ClientCert with 403
+func (rule RouteRule) requireClientCert(w http.ResponseWriter, r *http.Request) *http.Request {
+	cert := clientCertificate(r)
+	if cert == nil {
+		httpError(w, r, "client certificate required for "+r.URL.Path, http.StatusForbidden)
+		return nil
+	}
+
+	if !rule.ClientCert.Allows(cert) {
+		httpError(w, r, "client certificate not allowed for "+r.URL.Path, http.StatusForbidden)
+		return nil
+	}
+
+	return r
+}
+
+// forwardClientCert is a RequestModifier which passes the verified client certificate subject to the destination
+func (rule RouteRule) forwardClientCert(out, in *http.Request) {
+	out.Header.Del(rule.ClientCert.Header)
+
+	if cert := clientCertificate(in); cert != nil {
+		out.Header.Set(rule.ClientCert.Header, cert.Subject.String())
+	}
+}
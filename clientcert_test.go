@@ -0,0 +1,119 @@
+package rsrp_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/quells/rsrp"
+)
+
+func TestRouteRule_ClientCert(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "proxy", "proxy.example.com")
+	adminCert, adminKey := ca.issue(t, dir, "admin")
+	otherCert, otherKey := ca.issue(t, dir, "other")
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Client-Subject")))
+	}))
+	defer backend.Close()
+
+	routes, err := rsrp.ConvertRules([]rsrp.RouteRuleConfig{
+		{
+			Match:       "^/public$",
+			Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+			Destination: backend.URL,
+		},
+		{
+			Match:       "^/admin$",
+			Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+			Destination: backend.URL,
+			ClientCert: &rsrp.ClientCertConfig{
+				Subjects: []string{"^CN=admin,"},
+				Issuers:  []string{"CN=ca"},
+				Header:   "X-Client-Subject",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ConvertRules() unexpected error: %s", err.Error())
+	}
+
+	listener, err := rsrp.NewListener(rsrp.ListenerConfig{
+		TLS: &rsrp.TLSConfig{
+			Certificates: []rsrp.CertificateConfig{{CertFile: serverCert, KeyFile: serverKey}},
+			ClientCAFile: ca.file,
+		},
+	}, http.HandlerFunc(rsrp.RouteAll(*routes)))
+	if err != nil {
+		t.Fatalf("NewListener() unexpected error: %s", err.Error())
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	go listener.Serve(l)
+	defer listener.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(path, certFile, keyFile string) (int, string) {
+		tlsConfig := &tls.Config{RootCAs: roots, ServerName: "proxy.example.com"}
+		if certFile != "" {
+			cert, _ := tls.LoadX509KeyPair(certFile, keyFile)
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+		req, _ := http.NewRequest(http.MethodGet, "https://"+l.Addr().String()+path, nil)
+		req.Header.Set("X-Client-Subject", "CN=spoofed")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Listener unexpected error: %s", err.Error())
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, string(body)
+	}
+
+	if status, _ := get("/public", "", ""); status != http.StatusOK {
+		t.Fatalf("RouteAll() expected a route without a policy to allow clients without certificates, got %d", status)
+	}
+	if status, _ := get("/admin", "", ""); status != http.StatusForbidden {
+		t.Fatalf("RouteAll() expected 403 without a client certificate, got %d", status)
+	}
+	if status, _ := get("/admin", otherCert, otherKey); status != http.StatusForbidden {
+		t.Fatalf("RouteAll() expected 403 for a certificate with the wrong subject, got %d", status)
+	}
+	if status, body := get("/admin", adminCert, adminKey); status != http.StatusOK || body != "CN=admin,O=rsrp" {
+		t.Fatalf("RouteAll() expected the verified subject to be forwarded, got %d %q", status, body)
+	}
+}
+
+func TestClientCertPolicy_SANs(t *testing.T) {
+	policy, err := rsrp.NewClientCertPolicy(rsrp.ClientCertConfig{SANs: []string{`^billing\.internal$`}})
+	if err != nil {
+		t.Fatalf("NewClientCertPolicy() unexpected error: %s", err.Error())
+	}
+
+	if !policy.Allows(&x509.Certificate{DNSNames: []string{"web.internal", "billing.internal"}}) {
+		t.Fatalf("ClientCertPolicy.Allows() expected a matching DNS name to be allowed")
+	}
+	if policy.Allows(&x509.Certificate{EmailAddresses: []string{"billing.internal@example.com"}}) {
+		t.Fatalf("ClientCertPolicy.Allows() expected a certificate without a matching SAN to be rejected")
+	}
+
+	if _, err := rsrp.NewClientCertPolicy(rsrp.ClientCertConfig{Issuers: []string{"("}}); err == nil {
+		t.Fatalf("NewClientCertPolicy() expected error for an invalid pattern")
+	}
+}
@@ -1,15 +1,48 @@
 package rsrp
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
 // Config holds configuration details for the reverse proxy
 type Config struct {
-	Routes []RouteRuleConfig `json:"routes"`
+	Routes    []RouteRuleConfig `json:"routes"`
+	Transport *TransportConfig  `json:"transport"`
+	Forwarded ForwardedConfig   `json:"forwarded"`
+	AccessLog *AccessLogConfig  `json:"accessLog"`
+	Listeners []ListenerConfig  `json:"listeners"`
+	RequestID *RequestIDConfig  `json:"requestId"`
+	Tracing   *TracingConfig    `json:"tracing"`
 }
 
 // A RouteRuleConfig is the on-disk representation of a RouteRule
 type RouteRuleConfig struct {
-	Match       string            `json:"match"`
-	Rewrite     RewriteRuleConfig `json:"rewrite"`
-	Destination string            `json:"destination"`
+	Name            string                `json:"name"`
+	Match           string                `json:"match"`
+	Host            string                `json:"host"`
+	Methods         []string              `json:"methods"`
+	Headers         map[string]string     `json:"headers"`
+	Query           map[string]string     `json:"query"`
+	Rewrite         RewriteRuleConfig     `json:"rewrite"`
+	ReverseRewrite  bool                  `json:"reverseRewrite"`
+	RequestHeaders  *HeaderRulesConfig    `json:"requestHeaders"`
+	ResponseHeaders *HeaderRulesConfig    `json:"responseHeaders"`
+	Destination     string                `json:"destination"`
+	Destinations    []DestinationConfig   `json:"destinations"`
+	Balance         BalanceConfig         `json:"balance"`
+	HealthCheck     *HealthCheckConfig    `json:"healthCheck"`
+	FlushInterval   Duration              `json:"flushInterval"`
+	Transport       *TransportConfig      `json:"transport"`
+	Retry           *RetryConfig          `json:"retry"`
+	CircuitBreaker  *CircuitBreakerConfig `json:"circuitBreaker"`
+	RateLimit       *RateLimitConfig      `json:"rateLimit"`
+	IPFilter        *IPFilterConfig       `json:"ipFilter"`
+	CORS            *CORSConfig           `json:"cors"`
+	ClientCert      *ClientCertConfig     `json:"clientCertificate"`
+	Auth            *AuthConfig           `json:"auth"`
+	JWT             *JWTConfig            `json:"jwt"`
 }
 
 // A RewriteRuleConfig is the on-disk representation of a RewriteRule
@@ -17,3 +50,256 @@ type RewriteRuleConfig struct {
 	Input  string `json:"from"`
 	Output string `json:"to"`
 }
+
+// A HeaderRulesConfig is the on-disk representation of HeaderRules.
+// Values in Set and Add are HeaderTemplates.
+type HeaderRulesConfig struct {
+	Add    map[string]string `json:"add"`
+	Set    map[string]string `json:"set"`
+	Remove []string          `json:"remove"`
+	Rename map[string]string `json:"rename"`
+}
+
+// A DestinationConfig is the on-disk representation of a Destination
+type DestinationConfig struct {
+	URL    string             `json:"url"`
+	Weight int                `json:"weight"`
+	TLS    *UpstreamTLSConfig `json:"tls"`
+}
+
+// An UpstreamTLSConfig describes how to connect to a Destination over TLS.
+// CAFile is a PEM bundle of certificate authorities to trust instead of the system pool,
+// and CertFile and KeyFile are a client certificate to present.
+// ServerName overrides the name used to verify the Destination's certificate.
+type UpstreamTLSConfig struct {
+	CAFile             string `json:"caFile"`
+	CertFile           string `json:"certFile"`
+	KeyFile            string `json:"keyFile"`
+	ServerName         string `json:"serverName"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+// A BalanceConfig describes how requests are distributed across a route's destinations
+type BalanceConfig struct {
+	Strategy string `json:"strategy"`
+	Header   string `json:"header"`
+	Cookie   string `json:"cookie"`
+}
+
+// A HealthCheckConfig is the on-disk representation of a HealthCheck
+type HealthCheckConfig struct {
+	Path               string   `json:"path"`
+	Interval           Duration `json:"interval"`
+	Timeout            Duration `json:"timeout"`
+	ExpectedStatus     int      `json:"expectedStatus"`
+	HealthyThreshold   int      `json:"healthyThreshold"`
+	UnhealthyThreshold int      `json:"unhealthyThreshold"`
+}
+
+// A TransportConfig describes the connections made to destinations
+type TransportConfig struct {
+	DialTimeout           Duration `json:"dialTimeout"`
+	TLSHandshakeTimeout   Duration `json:"tlsHandshakeTimeout"`
+	ResponseHeaderTimeout Duration `json:"responseHeaderTimeout"`
+	IdleConnTimeout       Duration `json:"idleConnTimeout"`
+	MaxIdleConns          int      `json:"maxIdleConns"`
+	MaxIdleConnsPerHost   int      `json:"maxIdleConnsPerHost"`
+	DisableHTTP2          bool     `json:"disableHTTP2"`
+	FollowRedirects       bool     `json:"followRedirects"`
+}
+
+// A RetryConfig is the on-disk representation of a RetryPolicy.
+// RetryOn lists status codes such as "503", status classes such as "5xx", or "network" for connection errors and timeouts.
+type RetryConfig struct {
+	MaxAttempts   int      `json:"maxAttempts"`
+	RetryOn       []string `json:"retryOn"`
+	Backoff       Duration `json:"backoff"`
+	MaxBackoff    Duration `json:"maxBackoff"`
+	PerTryTimeout Duration `json:"perTryTimeout"`
+	Budget        Duration `json:"budget"`
+	MaxBodyBytes  int      `json:"maxBodyBytes"`
+	NonIdempotent bool     `json:"nonIdempotent"`
+}
+
+// A CircuitBreakerConfig is the on-disk representation of a CircuitBreakerPolicy
+type CircuitBreakerConfig struct {
+	ConsecutiveFailures int                          `json:"consecutiveFailures"`
+	FailureRatio        float64                      `json:"failureRatio"`
+	MinimumRequests     int                          `json:"minimumRequests"`
+	Window              Duration                     `json:"window"`
+	CoolDown            Duration                     `json:"coolDown"`
+	HalfOpenRequests    int                          `json:"halfOpenRequests"`
+	Response            CircuitBreakerResponseConfig `json:"response"`
+}
+
+// A CircuitBreakerResponseConfig describes the response sent instead of proxying while a circuit is open
+type CircuitBreakerResponseConfig struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType"`
+	Body        string `json:"body"`
+}
+
+// A RateLimitConfig is the on-disk representation of a RateLimiter.
+// Rate is in requests per second.
+type RateLimitConfig struct {
+	Rate   float64 `json:"rate"`
+	Burst  int     `json:"burst"`
+	Key    string  `json:"key"`
+	Header string  `json:"header"`
+	Claim  string  `json:"claim"`
+}
+
+// An IPFilterConfig is the on-disk representation of an IPFilter.
+// Allow and Deny are CIDR ranges or single IP addresses.
+type IPFilterConfig struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// A CORSConfig is the on-disk representation of a CORSPolicy.
+// Origins are exact origins, patterns using * or regular expressions starting with ^, or "*" for any origin.
+// Headers may include "*" to allow any request header.
+type CORSConfig struct {
+	Origins        []string `json:"origins"`
+	Methods        []string `json:"methods"`
+	Headers        []string `json:"headers"`
+	ExposedHeaders []string `json:"exposedHeaders"`
+	Credentials    bool     `json:"credentials"`
+	MaxAge         Duration `json:"maxAge"`
+}
+
+// A ClientCertConfig is the on-disk representation of a ClientCertPolicy.
+// Subjects, SANs and Issuers are regular expressions.
+type ClientCertConfig struct {
+	Subjects []string `json:"subjects"`
+	SANs     []string `json:"sans"`
+	Issuers  []string `json:"issuers"`
+	Header   string   `json:"header"`
+}
+
+// An AuthConfig is the on-disk representation of an AuthPolicy.
+// HtpasswdFile holds "user:bcrypt hash" lines and APIKeysFile holds "user:key" lines.
+// APIKeyHeader defaults to X-Api-Key and Realm to rsrp.
+type AuthConfig struct {
+	HtpasswdFile string `json:"htpasswdFile"`
+	APIKeysFile  string `json:"apiKeysFile"`
+	APIKeyHeader string `json:"apiKeyHeader"`
+	Realm        string `json:"realm"`
+	UserHeader   string `json:"userHeader"`
+}
+
+// A JWTConfig is the on-disk representation of a JWTPolicy.
+// Keys are read from JWKSFile, a JSON Web Key Set, and from Keys.
+// Algorithms defaults to RS256 and ES256.
+// RequiredClaims maps claim names to required values, and ForwardClaims maps claim names to headers.
+type JWTConfig struct {
+	JWKSFile       string            `json:"jwksFile"`
+	Keys           []JWTKeyConfig    `json:"keys"`
+	Algorithms     []string          `json:"algorithms"`
+	Issuer         string            `json:"issuer"`
+	Audiences      []string          `json:"audiences"`
+	ClockSkew      Duration          `json:"clockSkew"`
+	RequiredClaims map[string]string `json:"requiredClaims"`
+	Scopes         []string          `json:"scopes"`
+	ForwardClaims  map[string]string `json:"forwardClaims"`
+}
+
+// A JWTKeyConfig is a static JWT key: either File, a PEM public key or certificate, or Secret, an HMAC secret.
+// KeyID is matched against the kid header of tokens, if both are set.
+type JWTKeyConfig struct {
+	KeyID  string `json:"kid"`
+	File   string `json:"file"`
+	Secret string `json:"secret"`
+}
+
+// A ForwardedConfig is the on-disk representation of a ForwardedPolicy
+type ForwardedConfig struct {
+	TrustedProxies []string `json:"trustedProxies"`
+	Forwarded      bool     `json:"forwarded"`
+}
+
+// A RequestIDConfig is the on-disk representation of a RequestIDPolicy.
+// Trust is "none", "proxies" or "all".
+type RequestIDConfig struct {
+	Header string `json:"header"`
+	Trust  string `json:"trust"`
+}
+
+// A TracingConfig is the on-disk representation of a Tracer.
+// Spans are exported to OTLPEndpoint, if set, with OTLPHeaders added to each export request.
+type TracingConfig struct {
+	ServiceName  string            `json:"serviceName"`
+	SampleRatio  float64           `json:"sampleRatio"`
+	OTLPEndpoint string            `json:"otlpEndpoint"`
+	OTLPHeaders  map[string]string `json:"otlpHeaders"`
+}
+
+// An AccessLogConfig describes where and in which format to write access logs.
+// Format is one of "json", "common", "combined" or "template"; Output is "stdout", "stderr" or a file path.
+type AccessLogConfig struct {
+	Format   string `json:"format"`
+	Template string `json:"template"`
+	Output   string `json:"output"`
+}
+
+// A ListenerConfig describes an address to serve on.
+// If TLS is set, the listener serves HTTPS. If RedirectToHTTPS is set, every request is redirected
+// to HTTPS on HTTPSPort instead of being routed.
+type ListenerConfig struct {
+	Addr            string     `json:"addr"`
+	TLS             *TLSConfig `json:"tls"`
+	RedirectToHTTPS bool       `json:"redirectToHTTPS"`
+	HTTPSPort       int        `json:"httpsPort"`
+}
+
+// A TLSConfig describes the certificates and protocol settings of an HTTPS listener.
+// MinVersion is one of "1.0", "1.1", "1.2" or "1.3"; CipherSuites are named as in crypto/tls.
+// If ClientCAFile is set, client certificates are verified against it;
+// ClientAuth is "optional" (the default) or "required".
+type TLSConfig struct {
+	Certificates   []CertificateConfig `json:"certificates"`
+	MinVersion     string              `json:"minVersion"`
+	CipherSuites   []string            `json:"cipherSuites"`
+	ReloadInterval Duration            `json:"reloadInterval"`
+	ClientCAFile   string              `json:"clientCAFile"`
+	ClientAuth     string              `json:"clientAuth"`
+}
+
+// A CertificateConfig is a certificate/key pair and the hostnames it serves.
+// If Hosts is empty, the DNS names in the certificate are used.
+type CertificateConfig struct {
+	Hosts    []string `json:"hosts"`
+	CertFile string   `json:"certFile"`
+	KeyFile  string   `json:"keyFile"`
+}
+
+// A Duration is a time.Duration written on disk as a string such as "1.5s"
+type Duration time.Duration
+
+// UnmarshalJSON conforms Duration to json.Unmarshaler.
+// Numbers are interpreted as nanoseconds, like time.Duration.
+func (d *Duration) UnmarshalJSON(data []byte) (err error) {
+	var v interface{}
+	err = json.Unmarshal(data, &v)
+	if err != nil {
+		return
+	}
+
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(value)
+	case string:
+		var parsed time.Duration
+		parsed, err = time.ParseDuration(value)
+		*d = Duration(parsed)
+	default:
+		err = fmt.Errorf("invalid duration %s", string(data))
+	}
+
+	return
+}
+
+// MarshalJSON conforms Duration to json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
@@ -0,0 +1,187 @@
+package rsrp
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A CORSPolicy answers CORS preflight requests for a route and sets CORS headers on its responses,
+// replacing any set by the destination.
+// Origins are the allowed origins; if AnyOrigin is set, every origin is allowed.
+// Methods and Headers may be requested in preflights; if AnyHeader is set, every header is allowed.
+// ExposedHeaders may be read by scripts, Credentials allows cookies and authentication,
+// and MaxAge is how long browsers may cache a preflight response.
+type CORSPolicy struct {
+	Origins        []*regexp.Regexp
+	AnyOrigin      bool
+	Methods        []string
+	Headers        []string
+	AnyHeader      bool
+	ExposedHeaders []string
+	Credentials    bool
+	MaxAge         time.Duration
+}
+
+// NewCORSPolicy converts a CORSConfig to a CORSPolicy.
+// Methods defaults to GET, HEAD and POST. Credentials may not be combined with the "*" origin.
+func NewCORSPolicy(config CORSConfig) (policy *CORSPolicy, err error) {
+	policy = &CORSPolicy{
+		Methods:        config.Methods,
+		ExposedHeaders: config.ExposedHeaders,
+		Credentials:    config.Credentials,
+		MaxAge:         time.Duration(config.MaxAge),
+	}
+
+	if len(policy.Methods) == 0 {
+		policy.Methods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+
+	for _, header := range config.Headers {
+		if header == "*" {
+			policy.AnyHeader = true
+			continue
+		}
+		policy.Headers = append(policy.Headers, header)
+	}
+
+	for _, origin := range config.Origins {
+		if origin == "*" {
+			policy.AnyOrigin = true
+			continue
+		}
+
+		var re *regexp.Regexp
+		re, err = compileOrigin(origin)
+		if err != nil {
+			return
+		}
+		policy.Origins = append(policy.Origins, re)
+	}
+
+	// Echoing every origin with credentials would let any site read authenticated responses
+	if policy.AnyOrigin && policy.Credentials {
+		err = fmt.Errorf("CORS credentials cannot be allowed for any origin; list the allowed origins instead")
+	}
+
+	return
+}
+
+// compileOrigin converts an origin pattern to a regexp.
+// Patterns starting with ^ are regular expressions; otherwise each * matches one DNS label or a port,
+// as in "https://*.example.com" or "http://localhost:*", and the rest matches exactly, ignoring case.
+func compileOrigin(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "^") {
+		return regexp.Compile(pattern)
+	}
+
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	return regexp.Compile(`(?i)^` + strings.Join(parts, `[^./:]+`) + `$`)
+}
+
+// AllowsOrigin reports whether the value of an Origin header is allowed
+func (policy *CORSPolicy) AllowsOrigin(origin string) bool {
+	return policy.AnyOrigin || matchesAny(policy.Origins, origin)
+}
+
+// allowsHeaders reports whether each header in an Access-Control-Request-Headers value is allowed
+func (policy *CORSPolicy) allowsHeaders(requested string) bool {
+	if policy.AnyHeader {
+		return true
+	}
+
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !containsMethod(policy.Headers, header) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPreflight reports whether a request is a CORS preflight
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// setOrigin adds the Access-Control-Allow-Origin and Access-Control-Allow-Credentials headers for an allowed origin
+func (policy *CORSPolicy) setOrigin(header http.Header, origin string) {
+	if policy.AnyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Add("Vary", "Origin")
+	if policy.Credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// cors is a Filter which answers CORS preflight requests for the RouteRule's CORS policy.
+// Preflights for origins, methods or headers which are not allowed receive 403.
+// For other requests from an allowed origin, CORS headers are added to the response,
+// including errors from later Filters.
+func (rule RouteRule) cors(w http.ResponseWriter, r *http.Request) *http.Request {
+	policy := rule.CORS
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return r
+	}
+
+	if !isPreflight(r) {
+		if policy.AllowsOrigin(origin) {
+			policy.setOrigin(w.Header(), origin)
+			if len(policy.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+			}
+		}
+		return r
+	}
+
+	requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+	if !policy.AllowsOrigin(origin) ||
+		!containsMethod(policy.Methods, r.Header.Get("Access-Control-Request-Method")) ||
+		!policy.allowsHeaders(requestedHeaders) {
+		httpError(w, r, "CORS request not allowed for "+r.URL.Path, http.StatusForbidden)
+		return nil
+	}
+
+	header := w.Header()
+	policy.setOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(policy.Methods, ", "))
+	if policy.AnyHeader {
+		if requestedHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", requestedHeaders)
+		}
+	} else if len(policy.Headers) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(policy.Headers, ", "))
+	}
+	if policy.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(ceilSeconds(policy.MaxAge)))
+	}
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// stripCORSHeaders is a ResponseModifier which removes the destination's CORS headers,
+// so that only those set by the RouteRule's CORS policy reach the client
+func (rule RouteRule) stripCORSHeaders(resp *http.Response, r *http.Request) error {
+	for name := range resp.Header {
+		if strings.HasPrefix(name, "Access-Control-") {
+			resp.Header.Del(name)
+		}
+	}
+
+	return nil
+}
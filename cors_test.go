@@ -0,0 +1,135 @@
+package rsrp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quells/rsrp"
+)
+
+func TestRouteRule_CORS(t *testing.T) {
+	var proxied int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&proxied, 1)
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("X-Request-Id", "1")
+	}))
+	defer backend.Close()
+
+	routes, err := rsrp.ConvertRules([]rsrp.RouteRuleConfig{{
+		Match:       "^/api/.*$",
+		Methods:     []string{"GET", "POST"},
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^/api(/.*)$", Output: "$1"},
+		Destination: backend.URL,
+		CORS: &rsrp.CORSConfig{
+			Origins:        []string{"https://app.example.com", "https://*.example.org"},
+			Methods:        []string{"GET", "POST"},
+			Headers:        []string{"Content-Type"},
+			ExposedHeaders: []string{"X-Request-Id"},
+			Credentials:    true,
+			MaxAge:         rsrp.Duration(10 * time.Minute),
+		},
+	}})
+	if err != nil {
+		t.Fatalf("ConvertRules() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll(*routes)))
+	defer server.Close()
+
+	send := func(method, origin string, header http.Header) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+"/api/orders", nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	preflight := func(origin, method, headers string) *http.Response {
+		return send(http.MethodOptions, origin, http.Header{
+			"Access-Control-Request-Method":  {method},
+			"Access-Control-Request-Headers": {headers},
+		})
+	}
+
+	resp := preflight("https://app.example.com", "POST", "content-type")
+	if resp.StatusCode != http.StatusNoContent ||
+		resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		resp.Header.Get("Access-Control-Allow-Credentials") != "true" ||
+		resp.Header.Get("Access-Control-Allow-Methods") != "GET, POST" ||
+		resp.Header.Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("RouteAll() expected the preflight to be answered, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	denied := map[string]*http.Response{
+		"origin":  preflight("https://evil.example.com", "POST", ""),
+		"headers": preflight("https://app.example.com", "POST", "X-Secret"),
+	}
+	for reason, resp := range denied {
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("RouteAll() expected 403 for a preflight with a disallowed %s, got %d", reason, resp.StatusCode)
+		}
+	}
+
+	// The route does not match methods it does not serve
+	if resp := preflight("https://app.example.com", "DELETE", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("RouteAll() expected 404 for a preflight for another method, got %d", resp.StatusCode)
+	}
+	if n := atomic.LoadInt32(&proxied); n != 0 {
+		t.Fatalf("RouteAll() expected preflights not to be proxied, got %d requests", n)
+	}
+
+	resp = send(http.MethodGet, "https://www.example.org", nil)
+	if origins := resp.Header["Access-Control-Allow-Origin"]; len(origins) != 1 || origins[0] != "https://www.example.org" {
+		t.Fatalf("RouteAll() expected the destination's CORS headers to be replaced, got %v", origins)
+	}
+	if resp.Header.Get("Access-Control-Expose-Headers") != "X-Request-Id" {
+		t.Fatalf("RouteAll() expected exposed headers, got %v", resp.Header)
+	}
+
+	resp = send(http.MethodGet, "https://a.b.example.org", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("RouteAll() expected no CORS headers for a disallowed origin, got %d %v", resp.StatusCode, resp.Header)
+	}
+}
+
+func TestCORSPolicy_AllowsOrigin(t *testing.T) {
+	policy, err := rsrp.NewCORSPolicy(rsrp.CORSConfig{
+		Origins: []string{"http://localhost:*", `^https://pr-[0-9]+\.preview\.example\.com$`},
+	})
+	if err != nil {
+		t.Fatalf("NewCORSPolicy() unexpected error: %s", err.Error())
+	}
+
+	cases := map[string]bool{
+		"http://localhost:3000":                true,
+		"http://LOCALHOST:8080":                true,
+		"http://localhost":                     false,
+		"https://pr-42.preview.example.com":    true,
+		"https://pr-x.preview.example.com":     false,
+		"https://pr-42.preview.example.com.cn": false,
+	}
+	for origin, expected := range cases {
+		if policy.AllowsOrigin(origin) != expected {
+			t.Fatalf("CORSPolicy.AllowsOrigin() expected %v for %s", expected, origin)
+		}
+	}
+
+	if _, err := rsrp.NewCORSPolicy(rsrp.CORSConfig{Origins: []string{"^("}}); err == nil {
+		t.Fatalf("NewCORSPolicy() expected error for an invalid regular expression")
+	}
+	if _, err := rsrp.NewCORSPolicy(rsrp.CORSConfig{Origins: []string{"*"}, Credentials: true}); err == nil {
+		t.Fatalf("NewCORSPolicy() expected error for credentials with any origin")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/quells/rsrp"
 )
@@ -24,23 +26,69 @@ func main() {
 		os.Exit(1)
 	}
 
-	routes, err := rsrp.ConvertRules(config.Routes)
+	routes, err := rsrp.ConvertConfig(*config)
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
 
-	// This is the only line which uses rsrp;
-	// everything else is just parsing command line arguments and setting up the HTTP server.
-	http.HandleFunc("/", rsrp.RouteAll(*routes))
+	// The router runs health checks for routes which configure them,
+	// and its routes can be replaced without restarting the server.
+	router := rsrp.NewRouter(*routes)
+	defer router.Close()
 
-	httpServer := &http.Server{
-		Addr: ":5000",
+	// Reload the config file on SIGHUP, keeping the old routes if the new config is invalid
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := router.LoadFile(os.Args[1]); err != nil {
+				log.Printf("could not reload config: %v", err)
+				continue
+			}
+			log.Printf("reloaded config from %s", os.Args[1])
+		}
+	}()
+
+	http.Handle("/", router)
+
+	// Metrics, circuit breaker states and route details are served on a separate port so they are not exposed alongside the proxied routes
+	metrics := rsrp.NewMetrics()
+	router.UseMetrics(metrics)
+	admin := http.NewServeMux()
+	admin.Handle("/", metrics)
+	admin.Handle("/breakers", router.CircuitBreakerHandler())
+	admin.Handle("/routes", router.AdminHandler())
+	admin.Handle("/match", router.AdminHandler())
+	go func() {
+		if err := http.ListenAndServe(":5050", admin); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	// Without any listeners configured, serve plain HTTP on port 5000
+	listenerConfigs := config.Listeners
+	if len(listenerConfigs) == 0 {
+		listenerConfigs = []rsrp.ListenerConfig{{Addr: ":5000"}}
 	}
 
-	if err := httpServer.ListenAndServe(); err != nil {
-		log.Fatal(err)
+	errs := make(chan error, len(listenerConfigs))
+	for _, listenerConfig := range listenerConfigs {
+		listener, err := rsrp.NewListener(listenerConfig, http.DefaultServeMux)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		listener.OnError = func(err error) {
+			log.Printf("could not reload certificate: %v", err)
+		}
+
+		go func() {
+			errs <- listener.ListenAndServe()
+		}()
 	}
+
+	log.Fatal(<-errs)
 }
 
 func usage() string {
@@ -0,0 +1,216 @@
+package rsrp
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// A ForwardedPolicy describes how X-Forwarded-* and Forwarded headers are added to proxied requests.
+// Incoming values are only kept when the request comes directly from one of the TrustedProxies;
+// otherwise they are overwritten. If Forwarded is set, an RFC 7239 Forwarded header is also added.
+type ForwardedPolicy struct {
+	TrustedProxies []*net.IPNet
+	Forwarded      bool
+}
+
+// NewForwardedPolicy converts a ForwardedConfig to a ForwardedPolicy
+func NewForwardedPolicy(config ForwardedConfig) (policy ForwardedPolicy, err error) {
+	policy.Forwarded = config.Forwarded
+	policy.TrustedProxies, err = parseCIDRs(config.TrustedProxies)
+	return
+}
+
+// parseCIDRs parses a list of CIDR ranges; bare IP addresses are treated as single-address ranges
+func parseCIDRs(cidrs []string) (networks []*net.IPNet, err error) {
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil {
+				bits := 8 * len(ip.To4())
+				if bits == 0 {
+					bits = 8 * net.IPv6len
+				}
+				cidr += "/" + strconv.Itoa(bits)
+			}
+		}
+
+		var network *net.IPNet
+		_, network, err = net.ParseCIDR(cidr)
+		if err != nil {
+			return
+		}
+
+		networks = append(networks, network)
+	}
+
+	return
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Trusts reports whether a request came directly from a trusted proxy
+func (policy ForwardedPolicy) Trusts(r *http.Request) bool {
+	return containsIP(policy.TrustedProxies, remoteIP(r))
+}
+
+// ClientIP returns the address of the client which made a request.
+// X-Forwarded-For is walked from the right for as long as each hop is a trusted proxy,
+// so the result cannot be spoofed by an untrusted client.
+func (policy ForwardedPolicy) ClientIP(r *http.Request) net.IP {
+	ip := remoteIP(r)
+	if !containsIP(policy.TrustedProxies, ip) {
+		return ip
+	}
+
+	hops := forwardedFor(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(hops[i])
+		if hop == nil {
+			break
+		}
+
+		ip = hop
+		if !containsIP(policy.TrustedProxies, ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// remoteIP returns the address of the immediate peer of a request
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// forwardedFor returns every address listed in a request's X-Forwarded-For headers
+func forwardedFor(r *http.Request) (hops []string) {
+	for _, header := range r.Header["X-Forwarded-For"] {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	return
+}
+
+// setForwardedHeaders is a RequestModifier which adds X-Forwarded-* and optionally Forwarded headers
+func (rule RouteRule) setForwardedHeaders(out, in *http.Request) {
+	trusted := rule.Forwarded.Trusts(in)
+
+	if !trusted {
+		for _, header := range []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Forwarded-Prefix", "Forwarded"} {
+			out.Header.Del(header)
+		}
+	}
+
+	peer := ""
+	if ip := remoteIP(in); ip != nil {
+		peer = ip.String()
+	}
+
+	if peer != "" {
+		if prior := strings.Join(out.Header["X-Forwarded-For"], ", "); prior != "" {
+			out.Header.Set("X-Forwarded-For", prior+", "+peer)
+		} else {
+			out.Header.Set("X-Forwarded-For", peer)
+		}
+	}
+
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+
+	if out.Header.Get("X-Forwarded-Proto") == "" {
+		out.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if out.Header.Get("X-Forwarded-Host") == "" {
+		out.Header.Set("X-Forwarded-Host", in.Host)
+	}
+
+	publicPrefix, _ := splitPrefix(in.URL.Path, rule.RewritePath(in.URL.Path))
+	if publicPrefix != "" {
+		if prior := out.Header.Get("X-Forwarded-Prefix"); prior != "" {
+			out.Header.Set("X-Forwarded-Prefix", strings.TrimSuffix(prior, "/")+publicPrefix)
+		} else {
+			out.Header.Set("X-Forwarded-Prefix", publicPrefix)
+		}
+	}
+
+	if rule.Forwarded.Forwarded {
+		element := "for=" + forwardedNode(peer) + ";host=" + quoteForwarded(in.Host) + ";proto=" + proto
+		if prior := strings.Join(out.Header["Forwarded"], ", "); prior != "" {
+			element = prior + ", " + element
+		}
+		out.Header.Set("Forwarded", element)
+	}
+}
+
+// forwardedNode formats an address as an RFC 7239 node
+func forwardedNode(ip string) string {
+	switch {
+	case ip == "":
+		return "unknown"
+	case strings.Contains(ip, ":"):
+		return `"[` + ip + `]"`
+	default:
+		return ip
+	}
+}
+
+// quoteForwarded quotes an RFC 7239 value if it is not a valid token
+func quoteForwarded(value string) string {
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return `"` + strings.Replace(value, `"`, `\"`, -1) + `"`
+		}
+	}
+
+	return value
+}
+
+// splitPrefix compares a public request path with the upstream path it was rewritten to,
+// and returns the prefixes which differ between them. The paths are assumed to share
+// a common suffix beginning at a path segment boundary; for example "/api/users" and
+// "/v1/users" yield "/api" and "/v1".
+func splitPrefix(public, upstream string) (publicPrefix, upstreamPrefix string) {
+	i, j := len(public), len(upstream)
+	for i > 0 && j > 0 && public[i-1] == upstream[j-1] {
+		i--
+		j--
+	}
+
+	// Only split on a segment boundary
+	suffix := public[i:]
+	if !strings.HasPrefix(suffix, "/") {
+		slash := strings.Index(suffix, "/")
+		if slash < 0 {
+			slash = len(suffix)
+		}
+		i += slash
+		j += slash
+	}
+
+	return public[:i], upstream[:j]
+}
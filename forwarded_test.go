@@ -0,0 +1,135 @@
+package rsrp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quells/rsrp"
+)
+
+// headerEcho responds with the request headers it received as JSON
+func headerEcho() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(r.Header)
+	}))
+}
+
+func proxiedHeaders(t *testing.T, rule rsrp.RouteRule, r *http.Request) http.Header {
+	recorder := httptest.NewRecorder()
+	rule.ServeHTTP(recorder, r)
+
+	header := http.Header{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &header); err != nil {
+		t.Fatalf("could not decode echoed headers %q: %v", recorder.Body.String(), err)
+	}
+
+	return header
+}
+
+func TestRouteRule_ForwardedHeaders(t *testing.T) {
+	backend := headerEcho()
+	defer backend.Close()
+
+	routes, err := rsrp.ConvertConfig(rsrp.Config{
+		Routes: []rsrp.RouteRuleConfig{{
+			Match:       "^/api/.*$",
+			Rewrite:     rsrp.RewriteRuleConfig{Input: "^/api(/.*)$", Output: "/v1$1"},
+			Destination: backend.URL,
+		}},
+		Forwarded: rsrp.ForwardedConfig{
+			TrustedProxies: []string{"10.0.0.0/8"},
+			Forwarded:      true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("ConvertConfig() unexpected error: %s", err.Error())
+	}
+	rule := (*routes)[0]
+
+	testCases := []struct {
+		name, remoteAddr, forwardedFor, forwardedProto string
+		expected                                       map[string]string
+	}{
+		{
+			"untrusted client", "203.0.113.7:1234", "198.51.100.1", "https",
+			map[string]string{
+				"X-Forwarded-For":    "203.0.113.7",
+				"X-Forwarded-Proto":  "http",
+				"X-Forwarded-Host":   "public.example.com",
+				"X-Forwarded-Prefix": "/api",
+				"Forwarded":          "for=203.0.113.7;host=public.example.com;proto=http",
+			},
+		},
+		{
+			"trusted proxy", "10.1.2.3:1234", "198.51.100.1", "https",
+			map[string]string{
+				"X-Forwarded-For":    "198.51.100.1, 10.1.2.3",
+				"X-Forwarded-Proto":  "https",
+				"X-Forwarded-Host":   "public.example.com",
+				"X-Forwarded-Prefix": "/api",
+			},
+		},
+		{
+			"ipv6 client", "[2001:db8::1]:1234", "", "",
+			map[string]string{
+				"X-Forwarded-For": "2001:db8::1",
+				"Forwarded":       `for="[2001:db8::1]";host=public.example.com;proto=http`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "http://public.example.com/api/users", nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		if tc.forwardedProto != "" {
+			r.Header.Set("X-Forwarded-Proto", tc.forwardedProto)
+		}
+
+		header := proxiedHeaders(t, rule, r)
+		for name, expected := range tc.expected {
+			if value := header.Get(name); value != expected {
+				t.Fatalf("RouteRule.ServeHTTP() expected %s for %s to be %q, got %q", name, tc.name, expected, value)
+			}
+		}
+	}
+}
+
+func TestForwardedPolicy_ClientIP(t *testing.T) {
+	policy, err := rsrp.NewForwardedPolicy(rsrp.ForwardedConfig{
+		TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"},
+	})
+	if err != nil {
+		t.Fatalf("NewForwardedPolicy() unexpected error: %s", err.Error())
+	}
+
+	testCases := []struct {
+		remoteAddr, forwardedFor, expected string
+	}{
+		{"203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.1:1234", "198.51.100.1, 192.0.2.1, 10.0.0.2", "198.51.100.1"},
+		{"10.0.0.1:1234", "spoofed, 198.51.100.2, 192.0.2.1", "198.51.100.2"},
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+	}
+
+	for _, tc := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+
+		if ip := policy.ClientIP(r); ip.String() != tc.expected {
+			t.Fatalf("ClientIP() expected %s from %s via %s, got %s", tc.expected, tc.remoteAddr, tc.forwardedFor, ip)
+		}
+	}
+
+	if _, err := rsrp.NewForwardedPolicy(rsrp.ForwardedConfig{TrustedProxies: []string{"not an ip"}}); err == nil {
+		t.Fatalf("NewForwardedPolicy() expected error for invalid trusted proxy")
+	}
+}
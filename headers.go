@@ -0,0 +1,153 @@
+package rsrp
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// headerVariable matches the variables in a header template which are not capture groups of a RouteRule's Match
+var headerVariable = regexp.MustCompile(`\$\$|\$\{(client_ip|request_id|env:\w+|rewrite:\w+)\}`)
+
+// A HeaderTemplate is a header value which may refer to properties of the request.
+// $1, ${1} and ${name} are capture groups of the RouteRule's Match, and ${rewrite:1} of its Rewrite input.
+// ${client_ip} is the address of the client, ${request_id} the ID of the request or its X-Request-Id header,
+// and ${env:NAME} an environment variable, read when the template is parsed. $$ is a literal $.
+type HeaderTemplate struct {
+	parts []templatePart
+}
+
+// A templatePart is either a literal, which may contain capture groups of the Match, or a variable
+type templatePart struct {
+	literal  string
+	variable string
+}
+
+// ParseHeaderTemplate parses a HeaderTemplate
+func ParseHeaderTemplate(template string) (parsed HeaderTemplate) {
+	literal := ""
+	last := 0
+	for _, loc := range headerVariable.FindAllStringSubmatchIndex(template, -1) {
+		literal += template[last:loc[0]]
+		last = loc[1]
+
+		variable := ""
+		if loc[2] >= 0 {
+			variable = template[loc[2]:loc[3]]
+		}
+
+		switch {
+		case variable == "":
+			literal += "$$"
+		case strings.HasPrefix(variable, "env:"):
+			literal += strings.Replace(os.Getenv(variable[len("env:"):]), "$", "$$", -1)
+		default:
+			parsed.parts = append(parsed.parts, templatePart{literal: literal}, templatePart{variable: variable})
+			literal = ""
+		}
+	}
+
+	parsed.parts = append(parsed.parts, templatePart{literal: literal + template[last:]})
+	return
+}
+
+// expand evaluates a HeaderTemplate for a request handled by a RouteRule
+func (template HeaderTemplate) expand(rule RouteRule, r *http.Request) string {
+	path := r.URL.Path
+	match := rule.Match.FindStringSubmatchIndex(path)
+
+	var value []byte
+	for _, part := range template.parts {
+		switch {
+		case part.variable == "":
+			value = rule.Match.ExpandString(value, part.literal, path, match)
+		case part.variable == "client_ip":
+			if ip := rule.Forwarded.ClientIP(r); ip != nil {
+				value = append(value, ip.String()...)
+			}
+		case part.variable == "request_id":
+			id := RequestID(r)
+			if id == "" {
+				id = r.Header.Get("X-Request-Id")
+			}
+			value = append(value, id...)
+		case strings.HasPrefix(part.variable, "rewrite:"):
+			input := rule.Rewrite.Input
+			value = input.ExpandString(value, "${"+part.variable[len("rewrite:"):]+"}", path, input.FindStringSubmatchIndex(path))
+		}
+	}
+
+	return string(value)
+}
+
+// A HeaderRules describes changes to the headers of a request or response.
+// They are applied in the order Remove, Rename, Set and Add:
+// Rename maps old header names to new ones, Set replaces any existing values and Add appends to them.
+type HeaderRules struct {
+	Remove []string
+	Rename map[string]string
+	Set    map[string]HeaderTemplate
+	Add    map[string]HeaderTemplate
+}
+
+// NewHeaderRules converts a HeaderRulesConfig to HeaderRules
+func NewHeaderRules(config HeaderRulesConfig) *HeaderRules {
+	return &HeaderRules{
+		Remove: config.Remove,
+		Rename: config.Rename,
+		Set:    parseHeaderTemplates(config.Set),
+		Add:    parseHeaderTemplates(config.Add),
+	}
+}
+
+func parseHeaderTemplates(values map[string]string) (templates map[string]HeaderTemplate) {
+	if len(values) == 0 {
+		return
+	}
+
+	templates = make(map[string]HeaderTemplate, len(values))
+	for name, value := range values {
+		templates[name] = ParseHeaderTemplate(value)
+	}
+
+	return
+}
+
+// apply changes header for a request handled by a RouteRule
+func (rules *HeaderRules) apply(header http.Header, rule RouteRule, r *http.Request) {
+	for _, name := range rules.Remove {
+		header.Del(name)
+	}
+
+	for from, to := range rules.Rename {
+		values := header[http.CanonicalHeaderKey(from)]
+		if len(values) == 0 {
+			continue
+		}
+
+		header.Del(from)
+		header.Del(to)
+		for _, value := range values {
+			header.Add(to, value)
+		}
+	}
+
+	for name, template := range rules.Set {
+		header.Set(name, template.expand(rule, r))
+	}
+	for name, template := range rules.Add {
+		header.Add(name, template.expand(rule, r))
+	}
+}
+
+// modifyRequestHeaders is a RequestModifier which applies the RouteRule's RequestHeaders
+func (rule RouteRule) modifyRequestHeaders(out, in *http.Request) {
+	rule.RequestHeaders.apply(out.Header, rule, in)
+}
+
+// modifyResponseHeaders is a ResponseModifier which applies the RouteRule's ResponseHeaders
+func (rule RouteRule) modifyResponseHeaders(resp *http.Response, r *http.Request) error {
+	rule.ResponseHeaders.apply(resp.Header, rule, r)
+	return nil
+}
@@ -0,0 +1,89 @@
+package rsrp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/quells/rsrp"
+)
+
+func TestRouteRule_HeaderRules(t *testing.T) {
+	os.Setenv("RSRP_TEST_DEPLOYMENT", "blue")
+	defer os.Unsetenv("RSRP_TEST_DEPLOYMENT")
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Powered-By", "php")
+		w.Header().Set("X-Internal", "backend-1")
+		json.NewEncoder(w).Encode(r.Header)
+	}))
+	defer backend.Close()
+
+	routes, err := rsrp.ConvertRules([]rsrp.RouteRuleConfig{{
+		Match:       "^/tenants/(?P<tenant>[^/]+)/(.*)$",
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^/tenants/([^/]+)(/.*)$", Output: "$2"},
+		Destination: backend.URL,
+		RequestHeaders: &rsrp.HeaderRulesConfig{
+			Set: map[string]string{
+				"X-Tenant":  "${tenant}",
+				"X-Path":    "$2",
+				"X-Rewrite": "${rewrite:1}",
+				"X-Client":  "${client_ip}",
+				"X-Env":     "${env:RSRP_TEST_DEPLOYMENT}-$$",
+			},
+			Add:    map[string]string{"X-Request": "id=${request_id}"},
+			Remove: []string{"Cookie"},
+			Rename: map[string]string{"X-Legacy": "X-Token"},
+		},
+		ResponseHeaders: &rsrp.HeaderRulesConfig{
+			Set:    map[string]string{"Cache-Control": "no-store"},
+			Remove: []string{"X-Powered-By"},
+			Rename: map[string]string{"X-Internal": "X-Backend"},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("ConvertRules() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll(*routes)))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/tenants/acme/orders", nil)
+	req.Header.Set("Cookie", "session=1")
+	req.Header.Set("X-Legacy", "secret")
+	req.Header.Set("X-Request", "client")
+	req.Header.Set("X-Request-Id", "abc")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	var proxied http.Header
+	json.NewDecoder(resp.Body).Decode(&proxied)
+
+	expected := map[string]string{
+		"X-Tenant":  "acme",
+		"X-Path":    "orders",
+		"X-Rewrite": "acme",
+		"X-Client":  "127.0.0.1",
+		"X-Env":     "blue-$",
+		"X-Token":   "secret",
+		"X-Legacy":  "",
+		"Cookie":    "",
+	}
+	for name, value := range expected {
+		if proxied.Get(name) != value {
+			t.Fatalf("RouteAll() expected request header %s to be %q, got %q", name, value, proxied.Get(name))
+		}
+	}
+	if values := proxied["X-Request"]; len(values) != 2 || values[1] != "id=abc" {
+		t.Fatalf("RouteAll() expected a request header to be added, got %v", values)
+	}
+
+	if resp.Header.Get("Cache-Control") != "no-store" || resp.Header.Get("X-Powered-By") != "" || resp.Header.Get("X-Backend") != "backend-1" {
+		t.Fatalf("RouteAll() expected response headers to be changed, got %v", resp.Header)
+	}
+}
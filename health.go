@@ -0,0 +1,181 @@
+package rsrp
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A HealthCheck describes how to actively probe the Destinations of a route.
+// A Destination is taken out of rotation after UnhealthyThreshold consecutive failed probes
+// and put back after HealthyThreshold consecutive successful probes.
+type HealthCheck struct {
+	Path               string
+	Interval, Timeout  time.Duration
+	ExpectedStatus     int
+	HealthyThreshold   int
+	UnhealthyThreshold int
+	Client             *http.Client
+}
+
+// NewHealthCheck converts a HealthCheckConfig to a HealthCheck, filling in defaults
+func NewHealthCheck(config HealthCheckConfig) (check *HealthCheck, err error) {
+	check = &HealthCheck{
+		Path:               config.Path,
+		Interval:           time.Duration(config.Interval),
+		Timeout:            time.Duration(config.Timeout),
+		ExpectedStatus:     config.ExpectedStatus,
+		HealthyThreshold:   config.HealthyThreshold,
+		UnhealthyThreshold: config.UnhealthyThreshold,
+		Client:             http.DefaultClient,
+	}
+
+	if check.Path == "" {
+		check.Path = "/"
+	}
+	if check.Interval <= 0 {
+		check.Interval = 10 * time.Second
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = 2 * time.Second
+	}
+	if check.HealthyThreshold <= 0 {
+		check.HealthyThreshold = 2
+	}
+	if check.UnhealthyThreshold <= 0 {
+		check.UnhealthyThreshold = 3
+	}
+
+	return
+}
+
+// Probe performs a single health check request against a Destination.
+// Destinations with their own TLS settings are probed with their own client.
+func (check *HealthCheck) Probe(d *Destination) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), check.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequest(http.MethodGet, healthCheckURL(d.URL)+check.Path, nil)
+	if err != nil {
+		return false
+	}
+	request = request.WithContext(ctx)
+
+	client := check.Client
+	if d.Client != nil {
+		client = d.Client
+	}
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return false
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if check.ExpectedStatus == 0 {
+		return 200 <= resp.StatusCode && resp.StatusCode < 300
+	}
+	return resp.StatusCode == check.ExpectedStatus
+}
+
+// healthCheckURL converts WebSocket destinations to their HTTP equivalents
+func healthCheckURL(destination string) string {
+	switch {
+	case strings.HasPrefix(destination, "ws://"):
+		return "http://" + destination[len("ws://"):]
+	case strings.HasPrefix(destination, "wss://"):
+		return "https://" + destination[len("wss://"):]
+	default:
+		return destination
+	}
+}
+
+// Healthy reports whether a Destination is in rotation
+func (d *Destination) Healthy() bool {
+	return atomic.LoadInt32(&d.unhealthy) == 0
+}
+
+func (d *Destination) setHealthy(healthy bool) {
+	if healthy {
+		atomic.StoreInt32(&d.unhealthy, 0)
+	} else {
+		atomic.StoreInt32(&d.unhealthy, 1)
+	}
+}
+
+// monitor probes a Destination every Interval until stop is closed
+func (check *HealthCheck) monitor(d *Destination, stop chan struct{}) {
+	ticker := time.NewTicker(check.Interval)
+	defer ticker.Stop()
+
+	successes, failures := 0, 0
+	for {
+		if check.Probe(d) {
+			successes, failures = successes+1, 0
+			if successes >= check.HealthyThreshold {
+				d.setHealthy(true)
+			}
+		} else {
+			successes, failures = 0, failures+1
+			if failures >= check.UnhealthyThreshold {
+				d.setHealthy(false)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// StartHealthChecks begins probing each Destination in the background.
+// It does nothing if the Balancer has no HealthCheck or the checks are already running.
+func (b *Balancer) StartHealthChecks() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.HealthCheck == nil || b.stop != nil {
+		return
+	}
+
+	b.stop = make(chan struct{})
+	for _, d := range b.Destinations {
+		go b.HealthCheck.monitor(d, b.stop)
+	}
+}
+
+// StopHealthChecks stops probing the Balancer's Destinations
+func (b *Balancer) StopHealthChecks() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stop != nil {
+		close(b.stop)
+		b.stop = nil
+	}
+}
+
+// StartHealthChecks begins health checks for every rule with a Balancer and
+// returns a function which stops them
+func StartHealthChecks(rules []RouteRule) (stop func()) {
+	for _, rule := range rules {
+		if rule.Balancer != nil {
+			rule.Balancer.StartHealthChecks()
+		}
+	}
+
+	return func() {
+		for _, rule := range rules {
+			if rule.Balancer != nil {
+				rule.Balancer.StopHealthChecks()
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package rsrp_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quells/rsrp"
+)
+
+func TestHealthCheck_Probe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		config   rsrp.HealthCheckConfig
+		expected bool
+	}{
+		{rsrp.HealthCheckConfig{Path: "/healthz"}, true},
+		{rsrp.HealthCheckConfig{Path: "/healthz", ExpectedStatus: http.StatusNoContent}, true},
+		{rsrp.HealthCheckConfig{Path: "/healthz", ExpectedStatus: http.StatusOK}, false},
+		{rsrp.HealthCheckConfig{Path: "/missing"}, false},
+	}
+
+	destination := &rsrp.Destination{URL: server.URL}
+	for _, tc := range testCases {
+		check, err := rsrp.NewHealthCheck(tc.config)
+		if err != nil {
+			t.Fatalf("NewHealthCheck() unexpected error: %s", err.Error())
+		}
+
+		if healthy := check.Probe(destination); healthy != tc.expected {
+			t.Fatalf("Probe() expected %+v to yield %t, got %t", tc.config, tc.expected, healthy)
+		}
+	}
+}
+
+func TestRouteAll_HealthCheck(t *testing.T) {
+	var failing int32
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("A"))
+	}))
+	defer serverA.Close()
+
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("B"))
+	}))
+	defer serverB.Close()
+
+	rule, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match: "^/.*$",
+		Rewrite: rsrp.RewriteRuleConfig{
+			Input:  "^(/.*)$",
+			Output: "$1",
+		},
+		Destinations: []rsrp.DestinationConfig{
+			{URL: serverA.URL},
+			{URL: serverB.URL},
+		},
+		HealthCheck: &rsrp.HealthCheckConfig{
+			Interval:           rsrp.Duration(5 * time.Millisecond),
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+
+	rules := []rsrp.RouteRule{*rule}
+	stop := rsrp.StartHealthChecks(rules)
+	defer stop()
+
+	atomic.StoreInt32(&failing, 1)
+	waitFor(t, func() bool { return !rule.Balancer.Destinations[0].Healthy() })
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll(rules)))
+	defer server.Close()
+
+	for i := 0; i < 4; i++ {
+		resp, err := http.Get(server.URL + "/")
+		if err != nil {
+			t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(data) != "B" {
+			t.Fatalf("RouteAll() expected unhealthy destination to be skipped, got %s", data)
+		}
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	waitFor(t, func() bool { return rule.Balancer.Destinations[0].Healthy() })
+}
+
+// waitFor polls a condition until it is true or the test times out
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: condition not met before deadline", t.Name())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,89 @@
+package rsrp
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopHeaders are meaningful only for a single connection and must not be forwarded by proxies.
+// See RFC 7230, section 6.1.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHopHeaders deletes hop-by-hop headers, including any listed in Connection
+func removeHopByHopHeaders(h http.Header) {
+	for _, value := range h["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// containsToken reports whether a comma-separated header contains a token, ignoring case
+func containsToken(values []string, token string) bool {
+	for _, value := range values {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// copyResponseHeader copies end-to-end headers from a proxied response,
+// announcing any trailers the response will have
+func copyResponseHeader(w http.ResponseWriter, resp *http.Response) {
+	header := make(http.Header, len(resp.Header))
+	for k, vs := range resp.Header {
+		header[k] = vs
+	}
+	removeHopByHopHeaders(header)
+
+	for k, vs := range header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+
+	// The client moves the Trailer header into the keys of resp.Trailer
+	for k := range resp.Trailer {
+		w.Header().Add("Trailer", k)
+	}
+}
+
+// copyTrailer copies the trailers of a proxied response once its body has been read.
+// Trailers which were not announced in the response header are sent using http.TrailerPrefix.
+func copyTrailer(w http.ResponseWriter, resp *http.Response) {
+	announced := make(map[string]bool)
+	for _, value := range w.Header()["Trailer"] {
+		for _, name := range strings.Split(value, ",") {
+			announced[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+
+	for k, vs := range resp.Trailer {
+		if !announced[k] {
+			k = http.TrailerPrefix + k
+		}
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+}
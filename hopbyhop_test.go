@@ -0,0 +1,112 @@
+package rsrp_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quells/rsrp"
+)
+
+func TestRedirectRequest_HopByHopHeaders(t *testing.T) {
+	request, _ := http.NewRequest(http.MethodGet, "http://localhost:3000/test", nil)
+	request.Header.Set("Connection", "keep-alive, X-Private")
+	request.Header.Set("Keep-Alive", "timeout=5")
+	request.Header.Set("X-Private", "secret")
+	request.Header.Set("Proxy-Authorization", "Basic abc")
+	request.Header.Set("Te", "trailers, deflate")
+	request.Header.Set("Upgrade", "h2c")
+	request.Header.Set("X-Header", "Some Value")
+
+	redirected, err := rsrp.RedirectRequest(request, "http://localhost:3001/test")
+	if err != nil {
+		t.Fatalf("RedirectRequest() unexpected error: %s", err.Error())
+	}
+
+	for _, name := range []string{"Connection", "Keep-Alive", "X-Private", "Proxy-Authorization", "Upgrade"} {
+		if value := redirected.Header.Get(name); value != "" {
+			t.Fatalf("RedirectRequest() expected %s to be removed, got %s", name, value)
+		}
+	}
+
+	if value := redirected.Header.Get("Te"); value != "trailers" {
+		t.Fatalf("RedirectRequest() expected Te to be trailers, got %s", value)
+	}
+
+	if value := redirected.Header.Get("X-Header"); value != "Some Value" {
+		t.Fatalf("RedirectRequest() expected X-Header to be kept, got %s", value)
+	}
+}
+
+func TestRouteAll_HopByHopResponseHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "X-Private")
+		w.Header().Set("X-Private", "secret")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Public", "visible")
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	rule, _ := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:       "^/$",
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/)$", Output: "$1"},
+		Destination: backend.URL,
+	})
+
+	recorder := httptest.NewRecorder()
+	rule.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	header := recorder.Result().Header
+	for _, name := range []string{"X-Private", "Keep-Alive"} {
+		if value := header.Get(name); value != "" {
+			t.Fatalf("RouteRule.ServeHTTP() expected %s to be removed, got %s", name, value)
+		}
+	}
+
+	if value := header.Get("X-Public"); value != "visible" {
+		t.Fatalf("RouteRule.ServeHTTP() expected X-Public to be kept, got %s", value)
+	}
+}
+
+func TestRouteAll_Trailers(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("body"))
+		w.(http.Flusher).Flush()
+		w.Header().Set("X-Checksum", "abc123")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "unannounced")
+	}))
+	defer backend.Close()
+
+	rule, _ := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:       "^/$",
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/)$", Output: "$1"},
+		Destination: backend.URL,
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(data) != "body" {
+		t.Fatalf("RouteAll() expected body, got %s", data)
+	}
+
+	expected := map[string]string{
+		"X-Checksum": "abc123",
+		"X-Late":     "unannounced",
+	}
+	for name, value := range expected {
+		if trailer := resp.Trailer.Get(name); trailer != value {
+			t.Fatalf("RouteAll() expected trailer %s to be %s, got %q", name, value, trailer)
+		}
+	}
+}
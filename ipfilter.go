@@ -0,0 +1,45 @@
+package rsrp
+
+import (
+	"net"
+	"net/http"
+)
+
+// An IPFilter restricts which client addresses may use a route.
+// An address in Deny is always rejected; otherwise, if Allow is not empty, the address must be in it.
+type IPFilter struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// NewIPFilter converts an IPFilterConfig to an IPFilter
+func NewIPFilter(config IPFilterConfig) (filter *IPFilter, err error) {
+	filter = &IPFilter{}
+
+	filter.Allow, err = parseCIDRs(config.Allow)
+	if err != nil {
+		return
+	}
+	filter.Deny, err = parseCIDRs(config.Deny)
+	return
+}
+
+// Allows reports whether a client address may use the route
+func (filter *IPFilter) Allows(ip net.IP) bool {
+	if containsIP(filter.Deny, ip) {
+		return false
+	}
+
+	return len(filter.Allow) == 0 || containsIP(filter.Allow, ip)
+}
+
+// filterIP is a Filter which rejects requests from clients not allowed by the RouteRule's IPFilter with 403.
+// The client address is found with the RouteRule's ForwardedPolicy, so X-Forwarded-For is only honoured from trusted proxies.
+func (rule RouteRule) filterIP(w http.ResponseWriter, r *http.Request) *http.Request {
+	if !rule.IPFilter.Allows(rule.Forwarded.ClientIP(r)) {
+		httpError(w, r, "forbidden: "+r.URL.Path, http.StatusForbidden)
+		return nil
+	}
+
+	return r
+}
@@ -0,0 +1,102 @@
+package rsrp_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/quells/rsrp"
+	"github.com/quells/rsrp/relay"
+)
+
+func TestIPFilter_Allows(t *testing.T) {
+	filter, err := rsrp.NewIPFilter(rsrp.IPFilterConfig{
+		Allow: []string{"10.8.0.0/16", "2001:db8::/32"},
+		Deny:  []string{"10.8.13.7"},
+	})
+	if err != nil {
+		t.Fatalf("NewIPFilter() unexpected error: %s", err.Error())
+	}
+
+	cases := map[string]bool{
+		"10.8.1.1":    true,
+		"2001:db8::1": true,
+		"10.8.13.7":   false,
+		"10.9.0.1":    false,
+		"::1":         false,
+	}
+	for ip, expected := range cases {
+		if filter.Allows(net.ParseIP(ip)) != expected {
+			t.Fatalf("IPFilter.Allows() expected %v for %s", expected, ip)
+		}
+	}
+
+	denyOnly, _ := rsrp.NewIPFilter(rsrp.IPFilterConfig{Deny: []string{"192.0.2.0/24"}})
+	if !denyOnly.Allows(net.ParseIP("198.51.100.1")) || denyOnly.Allows(net.ParseIP("192.0.2.1")) {
+		t.Fatalf("IPFilter.Allows() expected a filter without an allow list to allow everything not denied")
+	}
+
+	if _, err := rsrp.NewIPFilter(rsrp.IPFilterConfig{Allow: []string{"10.8.0.0/33"}}); err == nil {
+		t.Fatalf("NewIPFilter() expected error for an invalid CIDR range")
+	}
+}
+
+func TestRouteRule_IPFilter(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			relay.EchoServer{}.ServeHTTP(w, r)
+		}
+	}))
+	defer backend.Close()
+
+	routes, err := rsrp.ConvertConfig(rsrp.Config{
+		Routes: []rsrp.RouteRuleConfig{
+			{
+				Match:       "^/admin$",
+				Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+				Destination: backend.URL,
+				IPFilter:    &rsrp.IPFilterConfig{Allow: []string{"10.8.0.0/16"}, Deny: []string{"10.8.13.7"}},
+			},
+			{
+				Match:        "^/ws$",
+				Rewrite:      rsrp.RewriteRuleConfig{Input: "^(/ws)$", Output: ""},
+				Destinations: []rsrp.DestinationConfig{{URL: "ws" + strings.TrimPrefix(backend.URL, "http")}},
+				IPFilter:     &rsrp.IPFilterConfig{Allow: []string{"10.8.0.0/16"}},
+			},
+		},
+		Forwarded: rsrp.ForwardedConfig{TrustedProxies: []string{"127.0.0.1", "::1"}},
+	})
+	if err != nil {
+		t.Fatalf("ConvertConfig() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll(*routes)))
+	defer server.Close()
+
+	cases := map[string]int{
+		"":                    http.StatusForbidden,
+		"10.8.1.1":            http.StatusOK,
+		"10.8.13.7":           http.StatusForbidden,
+		"10.8.1.1, 192.0.2.1": http.StatusForbidden,
+	}
+	for forwardedFor, expected := range cases {
+		if resp := getWithHeader(t, server.URL+"/admin", "X-Forwarded-For", forwardedFor); resp.StatusCode != expected {
+			t.Fatalf("RouteAll() expected %d for X-Forwarded-For %q, got %d", expected, forwardedFor, resp.StatusCode)
+		}
+	}
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	_, response, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || response == nil || response.StatusCode != http.StatusForbidden {
+		t.Fatalf("RouteAll() expected 403 for a WebSocket upgrade from a denied address, got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"X-Forwarded-For": {"10.8.1.1"}})
+	if err != nil {
+		t.Fatalf("RouteAll() expected a WebSocket upgrade from an allowed address, got %v", err)
+	}
+	conn.Close()
+}
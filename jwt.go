@@ -0,0 +1,513 @@
+package rsrp
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	// Register the hashes used by JWT algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// jwtAlgorithms maps the supported JWT signature algorithms to their key type and hash
+var jwtAlgorithms = map[string]struct {
+	kty  string
+	hash crypto.Hash
+}{
+	"RS256": {"RSA", crypto.SHA256},
+	"RS384": {"RSA", crypto.SHA384},
+	"RS512": {"RSA", crypto.SHA512},
+	"PS256": {"RSA", crypto.SHA256},
+	"PS384": {"RSA", crypto.SHA384},
+	"PS512": {"RSA", crypto.SHA512},
+	"ES256": {"EC", crypto.SHA256},
+	"ES384": {"EC", crypto.SHA384},
+	"ES512": {"EC", crypto.SHA512},
+	"HS256": {"oct", crypto.SHA256},
+	"HS384": {"oct", crypto.SHA384},
+	"HS512": {"oct", crypto.SHA512},
+}
+
+// A JWTKey is a key which may verify JWT signatures.
+// Key is an *rsa.PublicKey, an *ecdsa.PublicKey or a []byte HMAC secret.
+type JWTKey struct {
+	ID  string
+	Key interface{}
+}
+
+// A JWTPolicy requires requests to a route to carry a valid bearer JWT.
+// Tokens must be signed with one of the Algorithms by one of the Keys, and be within their validity period,
+// allowing for ClockSkew. If Issuer is set, it must match the iss claim, and if Audiences is set,
+// the aud claim must contain one of them.
+// Tokens must also have each of the RequiredClaims and Scopes; if they do not, the request is forbidden.
+// ForwardClaims maps claims to headers which pass their values to the destination.
+type JWTPolicy struct {
+	Keys           []JWTKey
+	Algorithms     []string
+	Issuer         string
+	Audiences      []string
+	ClockSkew      time.Duration
+	RequiredClaims map[string]string
+	Scopes         []string
+	ForwardClaims  map[string]string
+}
+
+// NewJWTPolicy converts a JWTConfig to a JWTPolicy, loading its keys
+func NewJWTPolicy(config JWTConfig) (policy *JWTPolicy, err error) {
+	policy = &JWTPolicy{
+		Algorithms:     config.Algorithms,
+		Issuer:         config.Issuer,
+		Audiences:      config.Audiences,
+		ClockSkew:      time.Duration(config.ClockSkew),
+		RequiredClaims: config.RequiredClaims,
+		Scopes:         config.Scopes,
+		ForwardClaims:  config.ForwardClaims,
+	}
+
+	if len(policy.Algorithms) == 0 {
+		policy.Algorithms = []string{"RS256", "ES256"}
+	}
+	for _, alg := range policy.Algorithms {
+		if _, ok := jwtAlgorithms[alg]; !ok {
+			err = fmt.Errorf("unsupported JWT algorithm %q", alg)
+			return
+		}
+	}
+
+	if config.JWKSFile != "" {
+		policy.Keys, err = LoadJWKS(config.JWKSFile)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, keyConfig := range config.Keys {
+		key := JWTKey{ID: keyConfig.KeyID}
+		switch {
+		case keyConfig.Secret != "":
+			key.Key = []byte(keyConfig.Secret)
+		case keyConfig.File != "":
+			key.Key, err = loadPublicKey(keyConfig.File)
+			if err != nil {
+				return
+			}
+		default:
+			err = fmt.Errorf("JWT key %q needs a file or a secret", keyConfig.KeyID)
+			return
+		}
+		policy.Keys = append(policy.Keys, key)
+	}
+
+	if len(policy.Keys) == 0 {
+		err = fmt.Errorf("JWT validation requires at least one key")
+	}
+
+	return
+}
+
+// loadPublicKey reads a PEM public key or certificate
+func loadPublicKey(filename string) (key interface{}, err error) {
+	var data []byte
+	data, err = ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		err = fmt.Errorf("no PEM data found in %s", filename)
+		return
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		err = fmt.Errorf("unsupported PEM block %q in %s", block.Type, filename)
+	}
+
+	return
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// LoadJWKS reads the signing keys from a JSON Web Key Set file.
+// Keys whose use is not "sig" are skipped.
+func LoadJWKS(filename string) (keys []JWTKey, err error) {
+	var data []byte
+	data, err = ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	err = json.Unmarshal(data, &set)
+	if err != nil {
+		err = fmt.Errorf("invalid JWKS %s: %v", filename, err)
+		return
+	}
+
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key interface{}
+		key, err = k.publicKey()
+		if err != nil {
+			err = fmt.Errorf("invalid key %q in JWKS %s: %v", k.Kid, filename, err)
+			return
+		}
+		keys = append(keys, JWTKey{ID: k.Kid, Key: key})
+	}
+
+	return
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	case "oct":
+		return decode(k.K)
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// Validate checks the signature and registered claims of a JWT and returns its claims
+func (policy *JWTPolicy) Validate(token string) (claims map[string]interface{}, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		err = errors.New("malformed token")
+		return
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	err = decodeSegment(parts[0], &header)
+	if err != nil {
+		return
+	}
+
+	if !containsString(policy.Algorithms, header.Alg) {
+		err = fmt.Errorf("algorithm %q not allowed", header.Alg)
+		return
+	}
+
+	var signature []byte
+	signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		err = errors.New("malformed signature")
+		return
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if !policy.verify(header.Alg, header.Kid, signed, signature) {
+		err = errors.New("invalid signature")
+		return
+	}
+
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return
+	}
+
+	err = policy.validateClaims(claims, time.Now())
+	return
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// verify checks a signature against each key which matches the key ID and algorithm
+func (policy *JWTPolicy) verify(alg, kid string, signed, signature []byte) bool {
+	algorithm := jwtAlgorithms[alg]
+
+	hasher := algorithm.hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	for _, key := range policy.Keys {
+		if kid != "" && key.ID != "" && key.ID != kid {
+			continue
+		}
+
+		switch k := key.Key.(type) {
+		case *rsa.PublicKey:
+			switch {
+			case algorithm.kty != "RSA":
+			case strings.HasPrefix(alg, "PS"):
+				options := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+				if rsa.VerifyPSS(k, algorithm.hash, digest, signature, options) == nil {
+					return true
+				}
+			default:
+				if rsa.VerifyPKCS1v15(k, algorithm.hash, digest, signature) == nil {
+					return true
+				}
+			}
+
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+			if algorithm.kty != "EC" || len(signature) != 2*size || k.Curve.Params().BitSize != ecdsaBits(alg) {
+				continue
+			}
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return true
+			}
+
+		case []byte:
+			if algorithm.kty != "oct" {
+				continue
+			}
+			mac := hmac.New(algorithm.hash.New, k)
+			mac.Write(signed)
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ecdsaBits returns the curve size required by an ECDSA algorithm
+func ecdsaBits(alg string) int {
+	switch alg {
+	case "ES256":
+		return 256
+	case "ES384":
+		return 384
+	default:
+		return 521
+	}
+}
+
+// validateClaims checks the time, issuer and audience claims
+func (policy *JWTPolicy) validateClaims(claims map[string]interface{}, now time.Time) error {
+	if exp, ok := claims["exp"].(float64); ok && now.After(unixTime(exp).Add(policy.ClockSkew)) {
+		return errors.New("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(unixTime(nbf).Add(-policy.ClockSkew)) {
+		return errors.New("token is not valid yet")
+	}
+
+	if policy.Issuer != "" && claims["iss"] != policy.Issuer {
+		return errors.New("token has the wrong issuer")
+	}
+
+	if len(policy.Audiences) > 0 {
+		found := false
+		for _, aud := range claimValues(claims["aud"]) {
+			if containsString(policy.Audiences, aud) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("token has the wrong audience")
+		}
+	}
+
+	return nil
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// Authorize checks that validated claims include the RequiredClaims and Scopes
+func (policy *JWTPolicy) Authorize(claims map[string]interface{}) error {
+	for name, value := range policy.RequiredClaims {
+		if !containsString(claimValues(claims[name]), value) {
+			return fmt.Errorf("token requires claim %s", name)
+		}
+	}
+
+	// Scopes are either a space separated scope claim or an scp array
+	scopes := claimValues(claims["scp"])
+	if scope, ok := claims["scope"].(string); ok {
+		scopes = append(scopes, strings.Fields(scope)...)
+	}
+	for _, scope := range policy.Scopes {
+		if !containsString(scopes, scope) {
+			return fmt.Errorf("token requires scope %s", scope)
+		}
+	}
+
+	return nil
+}
+
+// claimValues converts a claim which may be a single value or an array to strings
+func claimValues(claim interface{}) (values []string) {
+	switch value := claim.(type) {
+	case nil:
+	case []interface{}:
+		for _, v := range value {
+			values = append(values, claimString(v))
+		}
+	default:
+		values = append(values, claimString(value))
+	}
+	return
+}
+
+// claimString formats a claim for comparison or forwarding in a header
+func claimString(claim interface{}) string {
+	switch value := claim.(type) {
+	case string:
+		return value
+	case []interface{}:
+		return strings.Join(claimValues(value), ",")
+	default:
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken returns the token from an Authorization header using the Bearer scheme
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(auth[len("Bearer "):])
+}
+
+type claimsContextKey struct{}
+
+// Claims returns the claims of the JWT validated for a request, or nil if there are none
+func Claims(r *http.Request) map[string]interface{} {
+	claims, _ := r.Context().Value(claimsContextKey{}).(map[string]interface{})
+	return claims
+}
+
+// requireJWT is a Filter which rejects requests without a valid bearer JWT with 401,
+// and those whose token lacks required claims or scopes with 403.
+// The validated claims are available to later Filters and RequestModifiers through Claims.
+func (rule RouteRule) requireJWT(w http.ResponseWriter, r *http.Request) *http.Request {
+	token := bearerToken(r)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		httpError(w, r, "bearer token required for "+r.URL.Path, http.StatusUnauthorized)
+		return nil
+	}
+
+	claims, err := rule.JWT.Validate(token)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=\"invalid_token\", error_description=%q", err.Error()))
+		httpError(w, r, "invalid bearer token: "+err.Error(), http.StatusUnauthorized)
+		return nil
+	}
+
+	if err := rule.JWT.Authorize(claims); err != nil {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=\"insufficient_scope\", error_description=%q", err.Error()))
+		httpError(w, r, "forbidden: "+err.Error(), http.StatusForbidden)
+		return nil
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, claims))
+}
+
+// forwardClaims is a RequestModifier which passes the configured claims to the destination as headers,
+// replacing any values sent by the client
+func (rule RouteRule) forwardClaims(out, in *http.Request) {
+	claims := Claims(in)
+	for claim, header := range rule.JWT.ForwardClaims {
+		out.Header.Del(header)
+		if value, ok := claims[claim]; ok && value != nil {
+			out.Header.Set(header, claimString(value))
+		}
+	}
+}
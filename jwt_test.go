@@ -0,0 +1,244 @@
+package rsrp_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quells/rsrp"
+)
+
+// signToken creates a JWT signed with an RSA, ECDSA or HMAC key using SHA-256
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		if err == nil {
+			signature = append(fixedBytes(r, 32), fixedBytes(s, 32)...)
+		}
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatalf("could not sign token: %v", err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// fixedBytes encodes n as a big-endian number of size bytes
+func fixedBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// writeJWTKeys writes an RSA public key as PEM and an ECDSA public key as a JWKS to dir
+func writeJWTKeys(t *testing.T, dir string) (rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey, pemFile, jwksFile string) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pemFile = filepath.Join(dir, "rsa.pem")
+	ioutil.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "EC", "kid": "ec", "use": "sig", "crv": "P-256", "x": %q, "y": %q},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"}
+	]}`,
+		base64.RawURLEncoding.EncodeToString(fixedBytes(ecKey.X, 32)),
+		base64.RawURLEncoding.EncodeToString(fixedBytes(ecKey.Y, 32)))
+	jwksFile = filepath.Join(dir, "jwks.json")
+	ioutil.WriteFile(jwksFile, []byte(jwks), 0600)
+	return
+}
+
+func TestJWTPolicy_Validate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+
+	rsaKey, ecKey, pemFile, jwksFile := writeJWTKeys(t, dir)
+	secret := []byte("secret")
+
+	policy, err := rsrp.NewJWTPolicy(rsrp.JWTConfig{
+		JWKSFile:  jwksFile,
+		Keys:      []rsrp.JWTKeyConfig{{KeyID: "rsa", File: pemFile}, {Secret: string(secret)}},
+		Issuer:    "https://auth.example.com/",
+		Audiences: []string{"api", "admin"},
+		ClockSkew: rsrp.Duration(30 * time.Second),
+	})
+	if err != nil {
+		t.Fatalf("NewJWTPolicy() unexpected error: %s", err.Error())
+	}
+
+	now := time.Now().Unix()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"iss": "https://auth.example.com/", "aud": []string{"web", "api"}, "sub": "alice", "exp": now + 60}
+		for name, value := range extra {
+			c[name] = value
+		}
+		return c
+	}
+
+	valid := map[string]string{
+		"RSA key from PEM":       signToken(t, "RS256", "rsa", rsaKey, claims(nil)),
+		"EC key from JWKS":       signToken(t, "ES256", "ec", ecKey, claims(nil)),
+		"no key ID":              signToken(t, "ES256", "", ecKey, claims(nil)),
+		"expired within skew":    signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": now - 10})),
+		"not before within skew": signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"nbf": now + 10})),
+		"single audience":        signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"aud": "admin"})),
+	}
+	for name, token := range valid {
+		if c, err := policy.Validate(token); err != nil || c["sub"] != "alice" {
+			t.Fatalf("Validate() expected %s to be valid, got %v", name, err)
+		}
+	}
+
+	tampered := signToken(t, "RS256", "rsa", rsaKey, claims(nil))
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+	invalid := map[string]string{
+		"HMAC when not allowed": signToken(t, "HS256", "", secret, claims(nil)),
+		"wrong key ID":          signToken(t, "ES256", "rsa", ecKey, claims(nil)),
+		"expired":               signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": now - 60})),
+		"not yet valid":         signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"nbf": now + 60})),
+		"wrong issuer":          signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"iss": "https://evil.example.com/"})),
+		"wrong audience":        signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"aud": "web"})),
+		"tampered signature":    tampered,
+		"unsigned":              "eyJhbGciOiJub25lIn0.eyJzdWIiOiJhbGljZSJ9.",
+		"malformed":             "not a token",
+	}
+	for name, token := range invalid {
+		if _, err := policy.Validate(token); err == nil {
+			t.Fatalf("Validate() expected error for %s", name)
+		}
+	}
+
+	hmacPolicy, _ := rsrp.NewJWTPolicy(rsrp.JWTConfig{Keys: []rsrp.JWTKeyConfig{{Secret: string(secret)}}, Algorithms: []string{"HS256"}})
+	if _, err := hmacPolicy.Validate(signToken(t, "HS256", "", secret, claims(nil))); err != nil {
+		t.Fatalf("Validate() expected an HMAC token to be valid when allowed, got %v", err)
+	}
+}
+
+func TestNewJWTPolicy(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+
+	invalid := []rsrp.JWTConfig{
+		{},
+		{Keys: []rsrp.JWTKeyConfig{{Secret: "secret"}}, Algorithms: []string{"none"}},
+		{Keys: []rsrp.JWTKeyConfig{{KeyID: "empty"}}},
+		{Keys: []rsrp.JWTKeyConfig{{File: filepath.Join(dir, "missing.pem")}}},
+		{JWKSFile: filepath.Join(dir, "missing.json")},
+	}
+	for _, config := range invalid {
+		if _, err := rsrp.NewJWTPolicy(config); err == nil {
+			t.Fatalf("NewJWTPolicy() expected error for %+v", config)
+		}
+	}
+}
+
+func TestRouteRule_JWT(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+
+	rsaKey, _, pemFile, _ := writeJWTKeys(t, dir)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-User") + " " + r.Header.Get("X-Roles")))
+	}))
+	defer backend.Close()
+
+	routes, err := rsrp.ConvertRules([]rsrp.RouteRuleConfig{{
+		Match:       "^/orders$",
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+		Destination: backend.URL,
+		JWT: &rsrp.JWTConfig{
+			Keys:           []rsrp.JWTKeyConfig{{File: pemFile}},
+			RequiredClaims: map[string]string{"roles": "staff"},
+			Scopes:         []string{"orders:read"},
+			ForwardClaims:  map[string]string{"sub": "X-User", "roles": "X-Roles"},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("ConvertRules() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll(*routes)))
+	defer server.Close()
+
+	get := func(token string) (int, string, string) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/orders", nil)
+		req.Header.Set("X-User", "spoofed")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, resp.Header.Get("WWW-Authenticate"), string(body)
+	}
+
+	exp := time.Now().Add(time.Minute).Unix()
+	if status, challenge, _ := get(""); status != http.StatusUnauthorized || challenge != "Bearer" {
+		t.Fatalf("RouteAll() expected 401 with a challenge without a token, got %d %q", status, challenge)
+	}
+	if status, _, _ := get("not a token"); status != http.StatusUnauthorized {
+		t.Fatalf("RouteAll() expected 401 for an invalid token, got %d", status)
+	}
+
+	noScope := signToken(t, "RS256", "", rsaKey, map[string]interface{}{"sub": "bob", "roles": []string{"staff"}, "exp": exp})
+	if status, _, _ := get(noScope); status != http.StatusForbidden {
+		t.Fatalf("RouteAll() expected 403 for a token without the required scope, got %d", status)
+	}
+	noRole := signToken(t, "RS256", "", rsaKey, map[string]interface{}{"sub": "bob", "scope": "orders:read", "exp": exp})
+	if status, _, _ := get(noRole); status != http.StatusForbidden {
+		t.Fatalf("RouteAll() expected 403 for a token without the required claim, got %d", status)
+	}
+
+	token := signToken(t, "RS256", "", rsaKey, map[string]interface{}{
+		"sub":   "alice",
+		"roles": []string{"staff", "admin"},
+		"scope": "orders:read orders:write",
+		"exp":   exp,
+	})
+	if status, _, body := get(token); status != http.StatusOK || body != "alice staff,admin" {
+		t.Fatalf("RouteAll() expected verified claims to be forwarded, got %d %q", status, body)
+	}
+}
@@ -0,0 +1,106 @@
+package rsrp
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Listener serves a handler on one address, over HTTPS if it has Certificates.
+// Certificates are reloaded from disk every ReloadInterval while it is serving,
+// and reload errors are passed to OnError, which may be nil.
+type Listener struct {
+	Server         *http.Server
+	Certificates   *CertificateStore
+	ReloadInterval time.Duration
+	OnError        func(error)
+
+	mu            sync.Mutex
+	stopReloading func()
+}
+
+// NewListener converts a ListenerConfig to a Listener serving handler.
+// If the ListenerConfig redirects to HTTPS, the handler is not used.
+func NewListener(config ListenerConfig, handler http.Handler) (listener *Listener, err error) {
+	listener = &Listener{
+		Server: &http.Server{
+			Addr:    config.Addr,
+			Handler: handler,
+		},
+	}
+
+	if config.RedirectToHTTPS {
+		listener.Server.Handler = RedirectToHTTPS(config.HTTPSPort)
+	}
+
+	if config.TLS != nil {
+		listener.Server.TLSConfig, listener.Certificates, err = NewTLSConfig(*config.TLS)
+		if err != nil {
+			return
+		}
+		listener.ReloadInterval = durationOr(config.TLS.ReloadInterval, time.Minute)
+	}
+
+	return
+}
+
+// ListenAndServe listens on the Listener's address and serves until it is closed
+func (listener *Listener) ListenAndServe() error {
+	addr := listener.Server.Addr
+	if addr == "" {
+		addr = ":http"
+		if listener.Certificates != nil {
+			addr = ":https"
+		}
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return listener.Serve(l)
+}
+
+// Serve serves connections accepted from l until the Listener is closed, like http.Server.Serve
+func (listener *Listener) Serve(l net.Listener) error {
+	if listener.Certificates == nil {
+		return listener.Server.Serve(l)
+	}
+
+	listener.mu.Lock()
+	listener.stopReloading = listener.Certificates.Watch(listener.ReloadInterval, listener.OnError)
+	listener.mu.Unlock()
+
+	return listener.Server.ServeTLS(l, "", "")
+}
+
+// Close stops the Listener immediately, like http.Server.Close
+func (listener *Listener) Close() error {
+	listener.mu.Lock()
+	if listener.stopReloading != nil {
+		listener.stopReloading()
+	}
+	listener.mu.Unlock()
+
+	return listener.Server.Close()
+}
+
+// RedirectToHTTPS returns an http.Handler which permanently redirects every request to the same URL over HTTPS.
+// The port is omitted from the redirect if it is 0 or 443.
+func RedirectToHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 0 && port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,73 @@
+package rsrp_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/quells/rsrp"
+)
+
+func TestListener_TLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCertificate(t, dir, "server", "proxy.example.com")
+
+	listener, err := rsrp.NewListener(rsrp.ListenerConfig{
+		TLS: &rsrp.TLSConfig{
+			Certificates: []rsrp.CertificateConfig{{CertFile: certFile, KeyFile: keyFile}},
+		},
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	if err != nil {
+		t.Fatalf("NewListener() unexpected error: %s", err.Error())
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	go listener.Serve(l)
+	defer listener.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{ServerName: "proxy.example.com", InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get("https://" + l.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("Listener unexpected error: %s", err.Error())
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "secure" || resp.TLS == nil || resp.TLS.PeerCertificates[0].DNSNames[0] != "proxy.example.com" {
+		t.Fatalf("Listener expected to serve over TLS with the configured certificate, got %q", body)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	cases := []struct {
+		port     int
+		url      string
+		expected string
+	}{
+		{0, "http://example.com/a/b?c=d", "https://example.com/a/b?c=d"},
+		{443, "http://example.com:80/", "https://example.com/"},
+		{8443, "http://example.com:8080/x", "https://example.com:8443/x"},
+	}
+
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		rsrp.RedirectToHTTPS(c.port).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, c.url, nil))
+
+		if recorder.Code != http.StatusMovedPermanently || recorder.Header().Get("Location") != c.expected {
+			t.Fatalf("RedirectToHTTPS() expected redirect to %s, got %d %s", c.expected, recorder.Code, recorder.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package rsrp
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Matches reports whether a request satisfies every condition of the RouteRule:
+// its path, and if set, its host, method, headers and query parameters
+func (rule RouteRule) Matches(r *http.Request) bool {
+	if !rule.Match.MatchString(r.URL.Path) {
+		return false
+	}
+
+	if rule.Host != nil && !rule.Host.MatchString(requestHost(r)) {
+		return false
+	}
+
+	if len(rule.Methods) > 0 && !containsMethod(rule.Methods, r.Method) {
+		// A CORS preflight matches if the method it asks about would
+		if rule.CORS == nil || !isPreflight(r) || !containsMethod(rule.Methods, r.Header.Get("Access-Control-Request-Method")) {
+			return false
+		}
+	}
+
+	for name, pattern := range rule.Headers {
+		if !matchValues(pattern, r.Header[http.CanonicalHeaderKey(name)]) {
+			return false
+		}
+	}
+
+	if len(rule.Query) > 0 {
+		query := r.URL.Query()
+		for name, pattern := range rule.Query {
+			if !matchValues(pattern, query[name]) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// compileHost converts a host pattern such as "*.example.com" to a case-insensitive regexp.
+// Each * matches exactly one DNS label.
+func compileHost(pattern string) (*regexp.Regexp, error) {
+	labels := strings.Split(pattern, ".")
+	for i, label := range labels {
+		if label == "*" {
+			labels[i] = "[^.]+"
+		} else {
+			labels[i] = regexp.QuoteMeta(label)
+		}
+	}
+
+	return regexp.Compile(`(?i)^` + strings.Join(labels, `\.`) + `$`)
+}
+
+// compileValues compiles a map of header or query parameter patterns.
+// An empty pattern only requires the value to be present, and is stored as nil.
+func compileValues(patterns map[string]string) (compiled map[string]*regexp.Regexp, err error) {
+	if len(patterns) == 0 {
+		return
+	}
+
+	compiled = make(map[string]*regexp.Regexp, len(patterns))
+	for name, pattern := range patterns {
+		if pattern == "" {
+			compiled[name] = nil
+			continue
+		}
+
+		compiled[name], err = regexp.Compile(pattern)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// matchValues reports whether any of the values matches the pattern, or if the pattern is nil,
+// whether there are any values at all
+func matchValues(pattern *regexp.Regexp, values []string) bool {
+	if pattern == nil {
+		return len(values) > 0
+	}
+
+	for _, v := range values {
+		if pattern.MatchString(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestHost returns the host of a request without its port
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return host
+}
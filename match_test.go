@@ -0,0 +1,78 @@
+package rsrp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quells/rsrp"
+)
+
+func TestRouteRule_Matches(t *testing.T) {
+	rule, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:   "^/api/.*$",
+		Host:    "*.example.com",
+		Methods: []string{"GET", "POST"},
+		Headers: map[string]string{
+			"X-Api-Version": "^2(\\.[0-9]+)?$",
+			"Authorization": "",
+		},
+		Query: map[string]string{
+			"debug": "^(true|false)$",
+		},
+		Destination: "http://other",
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+
+	request := func(method, target string, modify func(*http.Request)) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("X-Api-Version", "2.1")
+		r.Header.Set("Authorization", "Bearer token")
+		if modify != nil {
+			modify(r)
+		}
+		return r
+	}
+
+	testCases := []struct {
+		name     string
+		request  *http.Request
+		expected bool
+	}{
+		{"all conditions", request("GET", "http://api.example.com/api/x?debug=true", nil), true},
+		{"host with port", request("POST", "http://API.example.com:8080/api/x?debug=false", nil), true},
+		{"wrong path", request("GET", "http://api.example.com/other?debug=true", nil), false},
+		{"bare domain", request("GET", "http://example.com/api/x?debug=true", nil), false},
+		{"nested subdomain", request("GET", "http://a.b.example.com/api/x?debug=true", nil), false},
+		{"wrong method", request("DELETE", "http://api.example.com/api/x?debug=true", nil), false},
+		{"wrong header", request("GET", "http://api.example.com/api/x?debug=true", func(r *http.Request) {
+			r.Header.Set("X-Api-Version", "3")
+		}), false},
+		{"missing header", request("GET", "http://api.example.com/api/x?debug=true", func(r *http.Request) {
+			r.Header.Del("Authorization")
+		}), false},
+		{"missing query", request("GET", "http://api.example.com/api/x", nil), false},
+		{"wrong query", request("GET", "http://api.example.com/api/x?debug=yes", nil), false},
+	}
+
+	for _, tc := range testCases {
+		if matched := rule.Matches(tc.request); matched != tc.expected {
+			t.Fatalf("Matches() expected %s to yield %t, got %t", tc.name, tc.expected, matched)
+		}
+	}
+}
+
+func TestNewRouteRule_InvalidMatchers(t *testing.T) {
+	testCases := []rsrp.RouteRuleConfig{
+		{Match: "^/$", Headers: map[string]string{"X-Header": "("}},
+		{Match: "^/$", Query: map[string]string{"q": "("}},
+	}
+
+	for _, tc := range testCases {
+		if _, err := rsrp.NewRouteRule(tc); err == nil {
+			t.Fatalf("NewRouteRule() expected error for %+v", tc)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package rsrp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quells/rsrp/relay"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency histogram
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects request, upstream and WebSocket statistics for RouteRules
+// and serves them in the Prometheus text exposition format.
+// A nil *Metrics is valid and records nothing.
+type Metrics struct {
+	mu sync.Mutex
+
+	requests       map[[3]string]int64 // route, destination, status class
+	latencies      map[string]*histogram
+	inFlight       map[string]int64
+	upstreamErrors map[[3]string]int64 // route, destination, kind
+
+	webSocketsOpen    map[string]int64
+	webSocketMessages map[[2]string]int64 // route, direction
+	webSocketBytes    map[[2]string]int64 // route, direction
+}
+
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+// NewMetrics creates an empty Metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:          make(map[[3]string]int64),
+		latencies:         make(map[string]*histogram),
+		inFlight:          make(map[string]int64),
+		upstreamErrors:    make(map[[3]string]int64),
+		webSocketsOpen:    make(map[string]int64),
+		webSocketMessages: make(map[[2]string]int64),
+		webSocketBytes:    make(map[[2]string]int64),
+	}
+}
+
+// Instrument sets the Metrics of each RouteRule in place
+func (m *Metrics) Instrument(rules []RouteRule) {
+	for i := range rules {
+		rules[i].Metrics = m
+	}
+}
+
+func (m *Metrics) requestStarted(route string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight[route]++
+}
+
+func (m *Metrics) requestFinished(route, destination string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight[route]--
+	m.requests[[3]string{route, destination, statusClass(status)}]++
+
+	h, ok := m.latencies[route]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(latencyBuckets))}
+		m.latencies[route] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (m *Metrics) upstreamError(route, destination, kind string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.upstreamErrors[[3]string{route, destination, kind}]++
+}
+
+// observeWebSocket returns relay.Options which record the WebSocket session
+func (m *Metrics) observeWebSocket(options relay.Options, route string) relay.Options {
+	if m == nil {
+		return options
+	}
+
+	nextOpen, nextMessage, nextClose := options.OnOpen, options.OnMessage, options.OnClose
+
+	options.OnOpen = func() {
+		m.mu.Lock()
+		m.webSocketsOpen[route]++
+		m.mu.Unlock()
+
+		if nextOpen != nil {
+			nextOpen()
+		}
+	}
+
+	options.OnMessage = func(inbound bool, size int) {
+		direction := "outbound"
+		if inbound {
+			direction = "inbound"
+		}
+
+		m.mu.Lock()
+		m.webSocketMessages[[2]string{route, direction}]++
+		m.webSocketBytes[[2]string{route, direction}] += int64(size)
+		m.mu.Unlock()
+
+		if nextMessage != nil {
+			nextMessage(inbound, size)
+		}
+	}
+
+	options.OnClose = func(stats relay.Stats) {
+		m.mu.Lock()
+		m.webSocketsOpen[route]--
+		m.mu.Unlock()
+
+		if nextClose != nil {
+			nextClose(stats)
+		}
+	}
+
+	return options
+}
+
+// RouteStats summarises the requests Metrics has recorded for one route
+type RouteStats struct {
+	Requests       map[string]int64 `json:"requests"`
+	InFlight       int64            `json:"inFlight"`
+	UpstreamErrors int64            `json:"upstreamErrors"`
+	MeanLatency    float64          `json:"meanLatencySeconds"`
+	WebSocketsOpen int64            `json:"webSocketsOpen"`
+}
+
+// routeStats returns the statistics recorded for a route, or nil if m is nil.
+// Requests are counted by status class across all destinations.
+func (m *Metrics) routeStats(route string) *RouteStats {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := &RouteStats{
+		Requests:       make(map[string]int64),
+		InFlight:       m.inFlight[route],
+		WebSocketsOpen: m.webSocketsOpen[route],
+	}
+	for key, count := range m.requests {
+		if key[0] == route {
+			stats.Requests[key[2]] += count
+		}
+	}
+	for key, count := range m.upstreamErrors {
+		if key[0] == route {
+			stats.UpstreamErrors += count
+		}
+	}
+	if h, ok := m.latencies[route]; ok && h.count > 0 {
+		stats.MeanLatency = h.sum / float64(h.count)
+	}
+
+	return stats
+}
+
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// ServeHTTP conforms Metrics to http.Handler, writing the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	m.WriteTo(out)
+}
+
+// WriteTo writes the current metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (n int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ew := &errWriter{w: w}
+
+	ew.header("rsrp_requests_total", "counter", "Requests proxied by route, destination and status class.")
+	for _, key := range sortedKeys3(m.requests) {
+		ew.sample("rsrp_requests_total", m.requests[key], "route", key[0], "destination", key[1], "code", key[2])
+	}
+
+	ew.header("rsrp_requests_in_flight", "gauge", "Requests currently being proxied by route.")
+	for _, route := range sortedKeys(m.inFlight) {
+		ew.sample("rsrp_requests_in_flight", m.inFlight[route], "route", route)
+	}
+
+	ew.header("rsrp_request_duration_seconds", "histogram", "Time to proxy a request by route.")
+	routes := make([]string, 0, len(m.latencies))
+	for route := range m.latencies {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		h := m.latencies[route]
+		for i, bound := range latencyBuckets {
+			ew.sample("rsrp_request_duration_seconds_bucket", h.counts[i], "route", route, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		ew.sample("rsrp_request_duration_seconds_bucket", h.count, "route", route, "le", "+Inf")
+		ew.sampleFloat("rsrp_request_duration_seconds_sum", h.sum, "route", route)
+		ew.sample("rsrp_request_duration_seconds_count", h.count, "route", route)
+	}
+
+	ew.header("rsrp_upstream_errors_total", "counter", "Failed attempts to reach a destination by route, destination and kind.")
+	for _, key := range sortedKeys3(m.upstreamErrors) {
+		ew.sample("rsrp_upstream_errors_total", m.upstreamErrors[key], "route", key[0], "destination", key[1], "kind", key[2])
+	}
+
+	ew.header("rsrp_websocket_sessions_open", "gauge", "WebSocket sessions currently being relayed by route.")
+	for _, route := range sortedKeys(m.webSocketsOpen) {
+		ew.sample("rsrp_websocket_sessions_open", m.webSocketsOpen[route], "route", route)
+	}
+
+	ew.header("rsrp_websocket_messages_total", "counter", "WebSocket messages relayed by route and direction.")
+	for _, key := range sortedKeys2(m.webSocketMessages) {
+		ew.sample("rsrp_websocket_messages_total", m.webSocketMessages[key], "route", key[0], "direction", key[1])
+	}
+
+	ew.header("rsrp_websocket_bytes_total", "counter", "WebSocket message bytes relayed by route and direction.")
+	for _, key := range sortedKeys2(m.webSocketBytes) {
+		ew.sample("rsrp_websocket_bytes_total", m.webSocketBytes[key], "route", key[0], "direction", key[1])
+	}
+
+	return ew.n, ew.err
+}
+
+// errWriter writes exposition lines until the first error
+type errWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+
+	var n int
+	n, ew.err = fmt.Fprintf(ew.w, format, args...)
+	ew.n += int64(n)
+}
+
+func (ew *errWriter) header(name, kind, help string) {
+	ew.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (ew *errWriter) sample(name string, value int64, labels ...string) {
+	ew.printf("%s%s %d\n", name, formatLabels(labels), value)
+}
+
+func (ew *errWriter) sampleFloat(name string, value float64, labels ...string) {
+	ew.printf("%s%s %s\n", name, formatLabels(labels), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats alternating label names and values
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys2(m map[[2]string]int64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return strings.Join(keys[i][:], "\x00") < strings.Join(keys[j][:], "\x00")
+	})
+	return keys
+}
+
+func sortedKeys3(m map[[3]string]int64) [][3]string {
+	keys := make([][3]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return strings.Join(keys[i][:], "\x00") < strings.Join(keys[j][:], "\x00")
+	})
+	return keys
+}
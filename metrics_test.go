@@ -0,0 +1,103 @@
+package rsrp_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/quells/rsrp"
+	"github.com/quells/rsrp/relay"
+)
+
+func scrape(t *testing.T, metrics *rsrp.Metrics) string {
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return recorder.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer backend.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL
+	down.Close()
+
+	echo := httptest.NewServer(relay.EchoServer{})
+	defer echo.Close()
+
+	routes, err := rsrp.ConvertRules([]rsrp.RouteRuleConfig{
+		{Match: "^/up/.*$", Rewrite: rsrp.RewriteRuleConfig{Input: "^/up(/.*)$", Output: "$1"}, Destination: backend.URL},
+		{Match: "^/down$", Destination: downURL},
+		{Match: "^/ws$", Destination: "ws" + strings.TrimPrefix(echo.URL, "http")},
+	})
+	if err != nil {
+		t.Fatalf("ConvertRules() unexpected error: %s", err.Error())
+	}
+
+	metrics := rsrp.NewMetrics()
+	router := rsrp.NewRouter(*routes)
+	defer router.Close()
+	router.UseMetrics(metrics)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	for _, path := range []string{"/up/ok", "/up/ok", "/up/missing", "/down"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Router unexpected error: %s", err.Error())
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("could not connect to echo server: %v", err)
+	}
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("could not read message: %v", err)
+	}
+
+	exposition := scrape(t, metrics)
+	expected := []string{
+		`rsrp_requests_total{route="^/up/.*$",destination="` + backend.URL + `",code="2xx"} 2`,
+		`rsrp_requests_total{route="^/up/.*$",destination="` + backend.URL + `",code="4xx"} 1`,
+		`rsrp_requests_total{route="^/down$",destination="` + downURL + `",code="5xx"} 1`,
+		`rsrp_requests_in_flight{route="^/up/.*$"} 0`,
+		`rsrp_request_duration_seconds_count{route="^/up/.*$"} 3`,
+		`rsrp_request_duration_seconds_bucket{route="^/up/.*$",le="+Inf"} 3`,
+		`rsrp_upstream_errors_total{route="^/down$",destination="` + downURL + `",kind="connection_refused"} 1`,
+		`rsrp_websocket_sessions_open{route="^/ws$"} 1`,
+		`# TYPE rsrp_request_duration_seconds histogram`,
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(exposition, line+"\n") {
+			t.Fatalf("Metrics expected %s in:\n%s", line, exposition)
+		}
+	}
+
+	// The relay may still be recording the echo when it reaches the client
+	waitFor(t, func() bool {
+		exposition := scrape(t, metrics)
+		return strings.Contains(exposition, `rsrp_websocket_messages_total{route="^/ws$",direction="inbound"} 1`+"\n") &&
+			strings.Contains(exposition, `rsrp_websocket_bytes_total{route="^/ws$",direction="inbound"} 5`+"\n")
+	})
+
+	conn.Close()
+	waitFor(t, func() bool {
+		return strings.Contains(scrape(t, metrics), `rsrp_websocket_sessions_open{route="^/ws$"} 0`)
+	})
+}
@@ -0,0 +1,212 @@
+package rsrp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An OTLPExporter sends Spans to an OpenTelemetry collector using OTLP over HTTP with JSON encoding.
+// Spans are sent in batches of up to BatchSize, or after Interval if fewer have ended.
+// Failures to send are passed to OnError, which may be nil; the Spans are dropped.
+type OTLPExporter struct {
+	Endpoint    string
+	ServiceName string
+	Headers     map[string]string
+	Client      *http.Client
+	BatchSize   int
+	Interval    time.Duration
+	OnError     func(error)
+
+	mu    sync.Mutex
+	batch []Span
+	timer *time.Timer
+}
+
+// NewOTLPExporter creates an OTLPExporter sending to an endpoint such as http://collector:4318/v1/traces.
+// ServiceName defaults to rsrp.
+func NewOTLPExporter(endpoint, serviceName string, headers map[string]string) *OTLPExporter {
+	if serviceName == "" {
+		serviceName = "rsrp"
+	}
+
+	return &OTLPExporter{
+		Endpoint:    endpoint,
+		ServiceName: serviceName,
+		Headers:     headers,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		BatchSize:   512,
+		Interval:    5 * time.Second,
+	}
+}
+
+// Export conforms OTLPExporter to Exporter
+func (e *OTLPExporter) Export(span Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.batch = append(e.batch, span)
+	if len(e.batch) >= e.BatchSize {
+		go e.send(e.take())
+		return
+	}
+
+	if e.timer == nil {
+		e.timer = time.AfterFunc(e.Interval, func() {
+			e.Flush()
+		})
+	}
+}
+
+// take removes the pending batch; the caller must hold e.mu
+func (e *OTLPExporter) take() (batch []Span) {
+	batch, e.batch = e.batch, nil
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	return
+}
+
+// Flush sends any pending Spans immediately
+func (e *OTLPExporter) Flush() error {
+	e.mu.Lock()
+	batch := e.take()
+	e.mu.Unlock()
+
+	return e.send(batch)
+}
+
+// send posts a batch of Spans to the collector
+func (e *OTLPExporter) send(batch []Span) (err error) {
+	if len(batch) == 0 {
+		return
+	}
+	defer func() {
+		if err != nil && e.OnError != nil {
+			e.OnError(err)
+		}
+	}()
+
+	var body []byte
+	body, err = json.Marshal(e.encode(batch))
+	if err != nil {
+		return
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest(http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.Headers {
+		req.Header.Set(name, value)
+	}
+
+	var resp *http.Response
+	resp, err = e.Client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		err = fmt.Errorf("OTLP export to %s failed with status %d", e.Endpoint, resp.StatusCode)
+	}
+	return
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// OTLP span kinds and status codes
+const (
+	otlpKindServer  = 2
+	otlpKindClient  = 3
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+// encode converts Spans to an OTLP ExportTraceServiceRequest
+func (e *OTLPExporter) encode(batch []Span) interface{} {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		s := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			TraceState:        span.TraceState,
+			Name:              span.Name,
+			Kind:              otlpKindServer,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if span.Kind == SpanKindClient {
+			s.Kind = otlpKindClient
+		}
+		if !span.ParentID.IsZero() {
+			s.ParentSpanID = span.ParentID.String()
+		}
+		if span.Error != "" {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+		for key, value := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpKeyValue{key, otlpValue(value)})
+		}
+
+		spans = append(spans, s)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpKeyValue{{"service.name", otlpValue(e.ServiceName)}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "github.com/quells/rsrp"},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+// otlpValue converts an attribute value to an OTLP AnyValue
+func otlpValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+}
@@ -0,0 +1,78 @@
+package rsrp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/quells/rsrp"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   map[string]interface{}
+	}
+	requests := make(chan request, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests <- request{r.Header, body}
+	}))
+	defer collector.Close()
+
+	exporter := rsrp.NewOTLPExporter(collector.URL, "", map[string]string{"X-Api-Key": "secret"})
+	exporter.Interval = time.Hour
+
+	var span rsrp.Span
+	span.Name = "GET /api"
+	span.Kind = rsrp.SpanKindClient
+	span.TraceID[15] = 1
+	span.SpanID[7] = 2
+	span.ParentID[7] = 3
+	span.Start = time.Unix(1, 0)
+	span.End = time.Unix(2, 0)
+	span.Attributes = map[string]interface{}{"rsrp.retry": 1}
+	span.Error = "Service Unavailable"
+	exporter.Export(span)
+
+	if err := exporter.Flush(); err != nil {
+		t.Fatalf("Flush() unexpected error: %s", err.Error())
+	}
+	req := <-requests
+	if req.header.Get("X-Api-Key") != "secret" || req.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Flush() expected configured headers, got %v", req.header)
+	}
+
+	resourceSpans := req.body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	service := resourceSpans["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if service["key"] != "service.name" || service["value"].(map[string]interface{})["stringValue"] != "rsrp" {
+		t.Fatalf("Flush() expected service.name rsrp, got %v", service)
+	}
+
+	spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	got := spans[0].(map[string]interface{})
+	if got["traceId"] != "00000000000000000000000000000001" || got["spanId"] != "0000000000000002" || got["parentSpanId"] != "0000000000000003" {
+		t.Fatalf("Flush() expected hex IDs, got %v", got)
+	}
+	if got["kind"] != 3.0 || got["startTimeUnixNano"] != "1000000000" || got["endTimeUnixNano"] != "2000000000" {
+		t.Fatalf("Flush() expected a client span with nanosecond times, got %v", got)
+	}
+	if status := got["status"].(map[string]interface{}); status["code"] != 2.0 || status["message"] != "Service Unavailable" {
+		t.Fatalf("Flush() expected an error status, got %v", status)
+	}
+	attribute := got["attributes"].([]interface{})[0].(map[string]interface{})
+	if attribute["key"] != "rsrp.retry" || attribute["value"].(map[string]interface{})["intValue"] != "1" {
+		t.Fatalf("Flush() expected an int attribute, got %v", attribute)
+	}
+
+	exporter.BatchSize = 1
+	exporter.Export(span)
+	select {
+	case <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Export() expected a full batch to be sent")
+	}
+}
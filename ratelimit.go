@@ -0,0 +1,204 @@
+package rsrp
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A RateLimiter limits how often clients may make requests to a route using a token bucket per client.
+// Each bucket holds up to Burst tokens and refills at Rate tokens per second.
+// Key chooses how clients are told apart:
+// "ip" uses the client IP, "header" uses the value of Header, "claim" uses the Claim of a bearer JWT
+// verified by the route's JWTPolicy, and "route" shares a single bucket between all clients.
+// Requests without a header or verified claim fall back to the client IP.
+type RateLimiter struct {
+	Rate   float64
+	Burst  int
+	Key    string
+	Header string
+	Claim  string
+	Store  RateLimitStore
+}
+
+// NewRateLimiter converts a RateLimitConfig to a RateLimiter using a MemoryStore
+func NewRateLimiter(config RateLimitConfig) (limiter *RateLimiter, err error) {
+	if config.Rate <= 0 {
+		err = fmt.Errorf("rate limit must have a positive rate, got %v", config.Rate)
+		return
+	}
+
+	limiter = &RateLimiter{
+		Rate:   config.Rate,
+		Burst:  intOr(config.Burst, int(math.Ceil(config.Rate))),
+		Key:    config.Key,
+		Header: config.Header,
+		Claim:  config.Claim,
+		Store:  NewMemoryStore(),
+	}
+
+	switch limiter.Key {
+	case "":
+		limiter.Key = "ip"
+	case "ip", "route":
+	case "header":
+		if limiter.Header == "" {
+			err = fmt.Errorf("rate limit keyed on a header must name the header")
+		}
+	case "claim":
+		if limiter.Claim == "" {
+			err = fmt.Errorf("rate limit keyed on a claim must name the claim")
+		}
+	default:
+		err = fmt.Errorf("unknown rate limit key %q", limiter.Key)
+	}
+
+	return
+}
+
+// A RateLimitResult is the outcome of taking a token from a bucket
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // until a token is available, if not Allowed
+	Reset      time.Duration // until the bucket is full again
+}
+
+// A RateLimitStore holds token buckets.
+// Take removes a token from the bucket identified by key, creating a full bucket if there is none.
+// Implementations must be safe for concurrent use.
+type RateLimitStore interface {
+	Take(key string, rate float64, burst int) RateLimitResult
+}
+
+// MemoryStore is a RateLimitStore which keeps buckets in memory.
+// Buckets which have refilled completely are discarded periodically.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  int
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Take conforms MemoryStore to RateLimitStore
+func (s *MemoryStore) Take(key string, rate float64, burst int) (result RateLimitResult) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		s.buckets[key] = b
+	}
+	b.rate, b.burst = rate, burst
+
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = secondsToDuration((float64(burst) - b.tokens) / rate)
+	return
+}
+
+// sweep discards buckets which would be full by now
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.burst) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.burst), b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// rateLimit is a Filter which rejects requests over the RouteRule's RateLimit with 429.
+// RateLimit-* headers describing the client's bucket are added to every response.
+func (rule RouteRule) rateLimit(w http.ResponseWriter, r *http.Request) *http.Request {
+	limiter := rule.RateLimit
+	result := limiter.Store.Take(rule.name()+" "+rule.rateLimitKey(r), limiter.Rate, limiter.Burst)
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limiter.Burst))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		httpError(w, r, "rate limit exceeded for "+r.URL.Path, http.StatusTooManyRequests)
+		return nil
+	}
+
+	return r
+}
+
+// rateLimitKey identifies the client of a request for rate limiting
+func (rule RouteRule) rateLimitKey(r *http.Request) string {
+	switch rule.RateLimit.Key {
+	case "route":
+		return "*"
+	case "header":
+		if value := r.Header.Get(rule.RateLimit.Header); value != "" {
+			return "header:" + value
+		}
+	case "claim":
+		if value := bearerClaim(r, rule.RateLimit.Claim); value != "" {
+			return "claim:" + value
+		}
+	}
+
+	ip := rule.Forwarded.ClientIP(r)
+	if ip == nil {
+		return "ip:" + r.RemoteAddr
+	}
+	return "ip:" + ip.String()
+}
+
+// bearerClaim reads a claim from the bearer JWT of a request, once the route's JWTPolicy has verified it.
+// Unverified tokens are never used, since a client could choose a new claim for every request.
+func bearerClaim(r *http.Request, claim string) string {
+	value, ok := Claims(r)[claim]
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,173 @@
+package rsrp_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quells/rsrp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := rsrp.NewMemoryStore()
+
+	for i := 0; i < 2; i++ {
+		if result := store.Take("client", 1, 2); !result.Allowed || result.Remaining != 1-i {
+			t.Fatalf("MemoryStore.Take() expected to allow the burst, got %+v", result)
+		}
+	}
+
+	result := store.Take("client", 1, 2)
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > time.Second {
+		t.Fatalf("MemoryStore.Take() expected to reject with a retry within a second, got %+v", result)
+	}
+
+	if result := store.Take("other", 1, 2); !result.Allowed {
+		t.Fatalf("MemoryStore.Take() expected separate buckets per key, got %+v", result)
+	}
+}
+
+func rateLimitedServer(t *testing.T, config rsrp.RateLimitConfig, route rsrp.RouteRuleConfig) (server *httptest.Server, stop func()) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	route.Match = "^/.*$"
+	route.Rewrite = rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"}
+	route.Destination = backend.URL
+	route.RateLimit = &config
+	rule, err := rsrp.NewRouteRule(route)
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+
+	server = httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	stop = func() {
+		server.Close()
+		backend.Close()
+	}
+	return
+}
+
+func getWithHeader(t *testing.T, url, name, value string) *http.Response {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if name != "" {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestRouteRule_RateLimit(t *testing.T) {
+	server, stop := rateLimitedServer(t, rsrp.RateLimitConfig{Rate: 0.1, Burst: 2, Key: "header", Header: "X-Api-Key"}, rsrp.RouteRuleConfig{})
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		resp := getWithHeader(t, server.URL+"/item", "X-Api-Key", "first")
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("RateLimit-Limit") != "2" {
+			t.Fatalf("RouteAll() expected the burst to be allowed with RateLimit headers, got %d %v", resp.StatusCode, resp.Header)
+		}
+	}
+
+	resp := getWithHeader(t, server.URL+"/item", "X-Api-Key", "first")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "10" || resp.Header.Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("RouteAll() expected 429 with Retry-After 10, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	if resp := getWithHeader(t, server.URL+"/item", "X-Api-Key", "second"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("RouteAll() expected a different key to have its own bucket, got %d", resp.StatusCode)
+	}
+}
+
+func TestRouteRule_RateLimitClaim(t *testing.T) {
+	secret := []byte("s3cret")
+	server, stop := rateLimitedServer(t, rsrp.RateLimitConfig{Rate: 0.1, Burst: 1, Key: "claim", Claim: "sub"}, rsrp.RouteRuleConfig{
+		JWT: &rsrp.JWTConfig{Keys: []rsrp.JWTKeyConfig{{KeyID: "hmac", Secret: string(secret)}}, Algorithms: []string{"HS256"}},
+	})
+	defer stop()
+
+	token := func(subject string) string {
+		return "Bearer " + signToken(t, "HS256", "hmac", secret, map[string]interface{}{"sub": subject})
+	}
+
+	if resp := getWithHeader(t, server.URL+"/item", "Authorization", token("alice")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("RouteAll() expected the first request to be allowed, got %d", resp.StatusCode)
+	}
+	if resp := getWithHeader(t, server.URL+"/item", "Authorization", token("alice")); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("RouteAll() expected the second request for the same subject to be limited, got %d", resp.StatusCode)
+	}
+	if resp := getWithHeader(t, server.URL+"/item", "Authorization", token("bob")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("RouteAll() expected another subject to be allowed, got %d", resp.StatusCode)
+	}
+
+	if _, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:       "^/.*$",
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+		Destination: "http://a",
+		RateLimit:   &rsrp.RateLimitConfig{Rate: 1, Key: "claim", Claim: "sub"},
+	}); err == nil {
+		t.Fatalf("NewRouteRule() expected error for a claim rate limit without jwt validation")
+	}
+}
+
+func TestRouteRule_RateLimitBeforeAuth(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	htpasswd := filepath.Join(dir, "htpasswd")
+	ioutil.WriteFile(htpasswd, []byte("alice:"+string(hash)+"\n"), 0600)
+
+	server, stop := rateLimitedServer(t, rsrp.RateLimitConfig{Rate: 0.1, Burst: 1}, rsrp.RouteRuleConfig{
+		Auth: &rsrp.AuthConfig{HtpasswdFile: htpasswd},
+	})
+	defer stop()
+
+	guess := func(password string) int {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/item", nil)
+		req.SetBasicAuth("alice", password)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := guess("wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("RouteAll() expected the first wrong password to be rejected with 401, got %d", status)
+	}
+	if status := guess("hunter2"); status != http.StatusTooManyRequests {
+		t.Fatalf("RouteAll() expected failed logins to count against the limit, got %d", status)
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	limiter, err := rsrp.NewRateLimiter(rsrp.RateLimitConfig{Rate: 2.5})
+	if err != nil {
+		t.Fatalf("NewRateLimiter() unexpected error: %s", err.Error())
+	}
+	if limiter.Key != "ip" || limiter.Burst != 3 {
+		t.Fatalf("NewRateLimiter() expected defaults of ip and a burst of 3, got %+v", limiter)
+	}
+
+	invalid := []rsrp.RateLimitConfig{
+		{},
+		{Rate: 1, Key: "header"},
+		{Rate: 1, Key: "claim"},
+		{Rate: 1, Key: "cookie"},
+	}
+	for _, config := range invalid {
+		if _, err := rsrp.NewRateLimiter(config); err == nil {
+			t.Fatalf("NewRateLimiter() expected error for %+v", config)
+		}
+	}
+}
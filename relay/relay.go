@@ -4,6 +4,8 @@ package relay
 import (
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -25,15 +27,26 @@ func NewHandler(targetURL string, options Options) Handler {
 	return Handler{targetURL, options}
 }
 
-// ServeHTTP conforms relay.Handler to http.Handler
+// ServeHTTP conforms relay.Handler to http.Handler.
+// Headers already set on w are included in the upgrade response.
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	external, err := h.Options.Upgrader.Upgrade(w, r, nil)
+	external, err := h.Options.Upgrader.Upgrade(w, r, w.Header())
 	if err != nil {
 		http.Error(w, "could not upgrade to websocket", http.StatusBadRequest)
 		return
 	}
 
-	internal, response, err := websocket.DefaultDialer.Dial(h.targetURL, nil)
+	dialer := h.Options.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+
+	var header http.Header
+	if h.Options.DialHeader != nil {
+		header = h.Options.DialHeader(r)
+	}
+
+	internal, response, err := dialer.Dial(h.targetURL, header)
 	if err != nil {
 		if response != nil {
 			for header, value := range response.Header {
@@ -67,13 +80,36 @@ type Pump struct {
 	external, internal *websocket.Conn
 	inbound, outbound  chan message
 	options            Options
+
+	stats   *Stats
+	done    chan struct{}
+	closing *sync.Once
 }
 
-// Options includes constants for pumping messages between two WebSocket connections
+// Options includes constants for pumping messages between two WebSocket connections.
+// The optional hooks observe a session: OnOpen is called when the pump starts,
+// OnMessage for each message relayed (inbound is true for messages from the external connection),
+// and OnClose once with the session's Stats after both connections are closed.
+// Dialer connects to the target; if it is nil, websocket.DefaultDialer is used.
+// DialHeader, if set, returns the headers sent to the target for an upgrade request.
 type Options struct {
 	Upgrader                        websocket.Upgrader
+	Dialer                          *websocket.Dialer
 	WriteWait, PongWait, PingPeriod time.Duration
 	MaxMessageSize                  int64
+	OnOpen                          func()
+	OnMessage                       func(inbound bool, size int)
+	OnClose                         func(Stats)
+	DialHeader                      func(r *http.Request) http.Header
+}
+
+// Stats describes a relayed WebSocket session.
+// Inbound messages travel from the external connection to the internal one, and outbound messages the reverse.
+type Stats struct {
+	MessagesInbound, BytesInbound   int64
+	MessagesOutbound, BytesOutbound int64
+	Start                           time.Time
+	Duration                        time.Duration
 }
 
 // DefaultOptions returns default relay.Option
@@ -91,23 +127,35 @@ func DefaultOptions() Options {
 func NewPump(external, internal *websocket.Conn, options Options) Pump {
 	inbound := make(chan message)
 	outbound := make(chan message)
-	return Pump{external, internal, inbound, outbound, options}
+	stats := &Stats{Start: time.Now()}
+	return Pump{external, internal, inbound, outbound, options, stats, make(chan struct{}), &sync.Once{}}
+}
+
+// stop signals the other goroutines of the pump that one of the connections has closed
+func (p *Pump) stop() {
+	p.closing.Do(func() {
+		close(p.done)
+	})
 }
 
 // read pipes messages from a WebSocket connection to a channel
 func (p *Pump) read(forExternal bool) {
 	var conn *websocket.Conn
 	var channel chan message
+	var messages, bytes *int64
 	if forExternal {
 		conn = p.external
 		channel = p.inbound
+		messages, bytes = &p.stats.MessagesInbound, &p.stats.BytesInbound
 	} else {
 		conn = p.internal
 		channel = p.outbound
+		messages, bytes = &p.stats.MessagesOutbound, &p.stats.BytesOutbound
 	}
 
 	defer func() {
 		conn.Close()
+		p.stop()
 	}()
 
 	conn.SetReadLimit(p.options.MaxMessageSize)
@@ -123,13 +171,28 @@ func (p *Pump) read(forExternal bool) {
 			break
 		}
 
-		channel <- message{messageType, body}
+		// Counted before it is handed on, so that stats are current once the message is relayed
+		atomic.AddInt64(messages, 1)
+		atomic.AddInt64(bytes, int64(len(body)))
+		if p.options.OnMessage != nil {
+			p.options.OnMessage(forExternal, len(body))
+		}
+
+		select {
+		case channel <- message{messageType, body}:
+		case <-p.done:
+			return
+		}
 	}
 }
 
 // write pipes messages from a pump's channels to its WebSocket connections.
 // It also periodically sends out ping messages to its WebSocket connections.
 func (p *Pump) write() {
+	if p.options.OnOpen != nil {
+		p.options.OnOpen()
+	}
+
 	externalTicker := time.NewTicker(p.options.PingPeriod)
 	internalTicker := time.NewTicker(p.options.PingPeriod)
 	defer func() {
@@ -137,10 +200,25 @@ func (p *Pump) write() {
 		internalTicker.Stop()
 		p.external.Close()
 		p.internal.Close()
+		p.stop()
+
+		if p.options.OnClose != nil {
+			p.options.OnClose(Stats{
+				Start:            p.stats.Start,
+				Duration:         time.Since(p.stats.Start),
+				MessagesInbound:  atomic.LoadInt64(&p.stats.MessagesInbound),
+				BytesInbound:     atomic.LoadInt64(&p.stats.BytesInbound),
+				MessagesOutbound: atomic.LoadInt64(&p.stats.MessagesOutbound),
+				BytesOutbound:    atomic.LoadInt64(&p.stats.BytesOutbound),
+			})
+		}
 	}()
 
 	for {
 		select {
+		case <-p.done:
+			return
+
 		case msg, ok := <-p.outbound:
 			p.external.SetWriteDeadline(time.Now().Add(p.options.WriteWait))
 			if !ok {
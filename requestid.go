@@ -0,0 +1,105 @@
+package rsrp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// validRequestID matches the inbound request IDs which are accepted
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:/+=-]{1,128}$`)
+
+// A RequestIDPolicy gives every request an ID, which is forwarded to the destination and returned to the client
+// in Header, and included in errors produced by rsrp.
+// An ID already present in Header is kept if Trust is "all", or "proxies" and the request
+// came directly from one of the ForwardedPolicy's trusted proxies; otherwise a new ID is generated.
+// If Header is empty, requests are not given IDs.
+type RequestIDPolicy struct {
+	Header string
+	Trust  string
+}
+
+// requestIDTrust lists the values accepted in RequestIDConfig.Trust
+var requestIDTrust = map[string]bool{"none": true, "proxies": true, "all": true}
+
+// NewRequestIDPolicy converts a RequestIDConfig to a RequestIDPolicy.
+// Header defaults to X-Request-Id and Trust to "proxies".
+func NewRequestIDPolicy(config RequestIDConfig) (policy RequestIDPolicy, err error) {
+	policy = RequestIDPolicy{Header: config.Header, Trust: config.Trust}
+	if policy.Header == "" {
+		policy.Header = "X-Request-Id"
+	}
+	if policy.Trust == "" {
+		policy.Trust = "proxies"
+	}
+
+	if !requestIDTrust[policy.Trust] {
+		err = fmt.Errorf("unknown request ID trust %q", policy.Trust)
+	}
+	return
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+type requestIDContextKey struct{}
+
+// RequestID returns the ID given to a request, or an empty string if it has none
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// assignRequestID gives a request an ID according to the RouteRule's RequestIDPolicy and returns it to the client
+func (rule RouteRule) assignRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	policy := rule.RequestID
+
+	id := r.Header.Get(policy.Header)
+	trusted := policy.Trust == "all" || policy.Trust == "proxies" && rule.Forwarded.Trusts(r)
+	if !trusted || !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+
+	w.Header().Set(policy.Header, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id))
+}
+
+// setRequestID is a RequestModifier which passes the request ID to the destination
+func (rule RouteRule) setRequestID(out, in *http.Request) {
+	out.Header.Set(rule.RequestID.Header, RequestID(in))
+}
+
+// stripRequestID is a ResponseModifier which removes a request ID echoed by the destination,
+// since the client has already been sent the request's ID
+func (rule RouteRule) stripRequestID(resp *http.Response, r *http.Request) error {
+	resp.Header.Del(rule.RequestID.Header)
+	return nil
+}
+
+// dialHeader returns the headers which pass the request ID and trace context to a WebSocket destination
+func (rule RouteRule) dialHeader(r *http.Request) http.Header {
+	header := http.Header{}
+	if rule.RequestID.Header != "" {
+		header.Set(rule.RequestID.Header, RequestID(r))
+	}
+	if span := spanFromRequest(r); span != nil {
+		span.inject(header)
+	}
+	return header
+}
+
+// httpError replies to a request with a plain text error like http.Error, including the request ID if there is one
+func httpError(w http.ResponseWriter, r *http.Request, message string, code int) {
+	if id := RequestID(r); id != "" {
+		message += " (request " + id + ")"
+	}
+
+	http.Error(w, message, code)
+}
@@ -0,0 +1,107 @@
+package rsrp_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/quells/rsrp"
+	"github.com/quells/rsrp/relay"
+)
+
+func TestRouteRule_RequestID(t *testing.T) {
+	dialled := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if websocket.IsWebSocketUpgrade(r) {
+			dialled <- id
+			relay.EchoServer{}.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("X-Request-Id", "echoed")
+		w.Write([]byte(id))
+	}))
+	defer backend.Close()
+
+	routes, err := rsrp.ConvertConfig(rsrp.Config{
+		Routes: []rsrp.RouteRuleConfig{
+			{
+				Match:       "^/api/.*$",
+				Rewrite:     rsrp.RewriteRuleConfig{Input: "^/api(/.*)$", Output: "$1"},
+				Destination: backend.URL,
+			},
+			{
+				Match:        "^/ws$",
+				Rewrite:      rsrp.RewriteRuleConfig{Input: "^(/ws)$", Output: ""},
+				Destinations: []rsrp.DestinationConfig{{URL: "ws" + strings.TrimPrefix(backend.URL, "http")}},
+			},
+			{
+				Match:       "^/down$",
+				Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/down)$", Output: "$1"},
+				Destination: "http://127.0.0.1:1",
+			},
+		},
+		Forwarded: rsrp.ForwardedConfig{TrustedProxies: []string{"127.0.0.1", "::1"}},
+		RequestID: &rsrp.RequestIDConfig{},
+	})
+	if err != nil {
+		t.Fatalf("ConvertConfig() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll(*routes)))
+	defer server.Close()
+
+	get := func(path, inbound string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if inbound != "" {
+			req.Header.Set("X-Request-Id", inbound)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body)
+	}
+
+	resp, body := get("/api/orders", "")
+	if ids := resp.Header["X-Request-Id"]; len(ids) != 1 || len(ids[0]) != 32 || ids[0] != body {
+		t.Fatalf("RouteAll() expected a generated ID to be forwarded and returned, got %v %q", ids, body)
+	}
+
+	if resp, body := get("/api/orders", "trace-42"); resp.Header.Get("X-Request-Id") != "trace-42" || body != "trace-42" {
+		t.Fatalf("RouteAll() expected the ID from a trusted proxy to be kept, got %q", body)
+	}
+	if _, body := get("/api/orders", "bad id\""); body == "bad id\"" || len(body) != 32 {
+		t.Fatalf("RouteAll() expected an invalid inbound ID to be replaced, got %q", body)
+	}
+
+	resp, body = get("/down", "")
+	if resp.StatusCode != http.StatusBadGateway || !strings.Contains(body, resp.Header.Get("X-Request-Id")) {
+		t.Fatalf("RouteAll() expected the request ID in the error body, got %d %q", resp.StatusCode, body)
+	}
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", http.Header{"X-Request-Id": {"socket-1"}})
+	if err != nil {
+		t.Fatalf("could not connect to echo server: %v", err)
+	}
+	conn.Close()
+	if id := <-dialled; id != "socket-1" || resp.Header.Get("X-Request-Id") != "socket-1" {
+		t.Fatalf("RouteAll() expected the request ID to be sent when dialling, got %q", id)
+	}
+}
+
+func TestNewRequestIDPolicy(t *testing.T) {
+	policy, err := rsrp.NewRequestIDPolicy(rsrp.RequestIDConfig{})
+	if err != nil || policy.Header != "X-Request-Id" || policy.Trust != "proxies" {
+		t.Fatalf("NewRequestIDPolicy() expected defaults, got %+v %v", policy, err)
+	}
+
+	if _, err := rsrp.NewRequestIDPolicy(rsrp.RequestIDConfig{Trust: "everyone"}); err == nil {
+		t.Fatalf("NewRequestIDPolicy() expected error for an unknown trust")
+	}
+}
@@ -0,0 +1,126 @@
+package rsrp
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A ResponseModifier changes a proxied response before its headers are copied to the client.
+// The incoming request is provided for reference; resp.Request is the proxied request.
+// Returning an error responds to the client with 502 instead.
+type ResponseModifier func(resp *http.Response, r *http.Request) error
+
+// reverseRewrite is a ResponseModifier which maps Location and Content-Location URLs
+// and Set-Cookie Path and Domain attributes from the destination back into the public URL space
+func (rule RouteRule) reverseRewrite(resp *http.Response, r *http.Request) error {
+	upstream := resp.Request.URL
+	publicPrefix, upstreamPrefix := splitPrefix(r.URL.Path, upstream.Path)
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	mapper := urlMapper{
+		upstreamHost:   upstream.Host,
+		upstreamPrefix: upstreamPrefix,
+		publicScheme:   scheme,
+		publicHost:     r.Host,
+		publicPrefix:   publicPrefix,
+	}
+
+	for _, name := range []string{"Location", "Content-Location"} {
+		if value := resp.Header.Get(name); value != "" {
+			resp.Header.Set(name, mapper.mapURL(value))
+		}
+	}
+
+	cookies := resp.Header["Set-Cookie"]
+	for i, cookie := range cookies {
+		cookies[i] = mapper.mapCookie(cookie, upstream.Hostname(), requestHost(r))
+	}
+
+	return nil
+}
+
+// urlMapper maps URLs under a destination's prefix to the corresponding public URLs
+type urlMapper struct {
+	upstreamHost, upstreamPrefix           string
+	publicScheme, publicHost, publicPrefix string
+}
+
+func (m urlMapper) mapURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+
+	if u.Host != "" {
+		// Absolute URLs pointing elsewhere are left alone
+		if !strings.EqualFold(u.Host, m.upstreamHost) {
+			return value
+		}
+		u.Scheme = m.publicScheme
+		u.Host = m.publicHost
+	} else if !strings.HasPrefix(u.Path, "/") {
+		// Relative references resolve correctly against the public URL
+		return value
+	}
+
+	path, ok := m.mapPath(u.Path)
+	if !ok {
+		return value
+	}
+	u.Path = path
+	u.RawPath = ""
+
+	return u.String()
+}
+
+// mapPath replaces the destination's prefix of a path with the public prefix
+func (m urlMapper) mapPath(path string) (mapped string, ok bool) {
+	if !strings.HasPrefix(path, m.upstreamPrefix) {
+		return
+	}
+
+	rest := path[len(m.upstreamPrefix):]
+	if rest != "" && !strings.HasPrefix(rest, "/") {
+		return
+	}
+
+	mapped = m.publicPrefix + rest
+	if mapped == "" {
+		mapped = "/"
+	}
+	ok = true
+
+	return
+}
+
+// mapCookie rewrites the Path and Domain attributes of a Set-Cookie header value,
+// leaving every other attribute untouched
+func (m urlMapper) mapCookie(cookie, upstreamHostname, publicHostname string) string {
+	parts := strings.Split(cookie, ";")
+	for i, part := range parts[1:] {
+		attribute := strings.TrimSpace(part)
+		eq := strings.Index(attribute, "=")
+		if eq < 0 {
+			continue
+		}
+		name, value := attribute[:eq], strings.TrimSpace(attribute[eq+1:])
+
+		switch strings.ToLower(name) {
+		case "path":
+			if path, ok := m.mapPath(value); ok {
+				parts[i+1] = " " + name + "=" + path
+			}
+		case "domain":
+			if strings.EqualFold(strings.TrimPrefix(value, "."), upstreamHostname) {
+				parts[i+1] = " " + name + "=" + publicHostname
+			}
+		}
+	}
+
+	return strings.Join(parts, ";")
+}
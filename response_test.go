@@ -0,0 +1,76 @@
+package rsrp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quells/rsrp"
+)
+
+func TestRouteRule_ReverseRewrite(t *testing.T) {
+	var backendURL string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/relative":
+			w.Header().Set("Location", "/v1/login?next=%2Fhome")
+		case "/v1/absolute":
+			w.Header().Set("Location", backendURL+"/v1/login")
+		case "/v1/external":
+			w.Header().Set("Location", "https://auth.example.org/login")
+		case "/v1/outside":
+			w.Header().Set("Location", "/other/place")
+		}
+		w.Header().Set("Content-Location", "/v1/resource/1")
+		w.Header().Add("Set-Cookie", "session=abc; Path=/v1; Domain=127.0.0.1; HttpOnly; SameSite=Lax")
+		w.Header().Add("Set-Cookie", "theme=dark; path=/v1/settings")
+		w.Header().Add("Set-Cookie", "other=1; Path=/elsewhere; Domain=example.org")
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer backend.Close()
+	backendURL = backend.URL
+
+	rule, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:          "^/app/.*$",
+		Rewrite:        rsrp.RewriteRuleConfig{Input: "^/app(/.*)$", Output: "/v1$1"},
+		ReverseRewrite: true,
+		Destination:    backend.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+
+	testCases := []struct {
+		path, location string
+	}{
+		{"/app/relative", "/app/login?next=%2Fhome"},
+		{"/app/absolute", "http://public.example.com/app/login"},
+		{"/app/external", "https://auth.example.org/login"},
+		{"/app/outside", "/other/place"},
+	}
+
+	for _, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		rule.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://public.example.com"+tc.path, nil))
+		header := recorder.Result().Header
+
+		if location := header.Get("Location"); location != tc.location {
+			t.Fatalf("RouteRule.ServeHTTP() expected Location for %s to be %s, got %s", tc.path, tc.location, location)
+		}
+
+		if location := header.Get("Content-Location"); location != "/app/resource/1" {
+			t.Fatalf("RouteRule.ServeHTTP() expected Content-Location to be /app/resource/1, got %s", location)
+		}
+
+		expectedCookies := []string{
+			"session=abc; Path=/app; Domain=public.example.com; HttpOnly; SameSite=Lax",
+			"theme=dark; path=/app/settings",
+			"other=1; Path=/elsewhere; Domain=example.org",
+		}
+		cookies := header["Set-Cookie"]
+		if strings.Join(cookies, "\n") != strings.Join(expectedCookies, "\n") {
+			t.Fatalf("RouteRule.ServeHTTP() expected cookies %q, got %q", expectedCookies, cookies)
+		}
+	}
+}
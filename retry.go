@@ -0,0 +1,235 @@
+package rsrp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A RetryPolicy describes when a failed proxied request is sent again, possibly to a different Destination.
+// Requests are only retried if their method is idempotent (or NonIdempotent is set)
+// and their body is empty or no larger than MaxBodyBytes, so that it can be replayed.
+type RetryPolicy struct {
+	MaxAttempts   int
+	Statuses      map[int]bool
+	Classes       map[int]bool
+	NetworkErrors bool
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	PerTryTimeout time.Duration
+	Budget        time.Duration
+	MaxBodyBytes  int64
+	NonIdempotent bool
+}
+
+// NewRetryPolicy converts a RetryConfig to a RetryPolicy, filling in defaults
+func NewRetryPolicy(config RetryConfig) (policy *RetryPolicy, err error) {
+	policy = &RetryPolicy{
+		MaxAttempts:   intOr(config.MaxAttempts, 2),
+		Statuses:      make(map[int]bool),
+		Classes:       make(map[int]bool),
+		Backoff:       durationOr(config.Backoff, 25*time.Millisecond),
+		MaxBackoff:    durationOr(config.MaxBackoff, time.Second),
+		PerTryTimeout: time.Duration(config.PerTryTimeout),
+		Budget:        time.Duration(config.Budget),
+		MaxBodyBytes:  int64(intOr(config.MaxBodyBytes, 64*1024)),
+		NonIdempotent: config.NonIdempotent,
+	}
+
+	retryOn := config.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = []string{"network", "502", "503", "504"}
+	}
+
+	for _, condition := range retryOn {
+		switch {
+		case condition == "network":
+			policy.NetworkErrors = true
+		case len(condition) == 3 && strings.HasSuffix(condition, "xx") && '1' <= condition[0] && condition[0] <= '5':
+			policy.Classes[int(condition[0]-'0')] = true
+		default:
+			var status int
+			status, err = strconv.Atoi(condition)
+			if err != nil || status < 100 || status > 599 {
+				err = fmt.Errorf("invalid retry condition %q", condition)
+				return
+			}
+			policy.Statuses[status] = true
+		}
+	}
+
+	return
+}
+
+// attempts returns how many times a request may be sent, and a copy of the request whose body can be replayed.
+// A nil *RetryPolicy always allows one attempt.
+func (policy *RetryPolicy) attempts(r *http.Request) (int, *http.Request) {
+	if policy == nil || policy.MaxAttempts <= 1 {
+		return 1, r
+	}
+
+	if !policy.NonIdempotent && !isIdempotent(r.Method) {
+		return 1, r
+	}
+
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return policy.MaxAttempts, r
+	}
+
+	if r.ContentLength < 0 || r.ContentLength > policy.MaxBodyBytes {
+		return 1, r
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+
+	replayable := r.WithContext(r.Context())
+	replayable.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		// The body is partially consumed; send what was read once and let the destination reject it
+		return 1, replayable
+	}
+
+	// Each attempt reads its own copy of the body, see openBody
+	replayable.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return policy.MaxAttempts, replayable
+}
+
+// openBody returns a copy of the request with a fresh body, if the body can be replayed
+func openBody(r *http.Request) *http.Request {
+	if r.GetBody == nil {
+		return r
+	}
+
+	body, err := r.GetBody()
+	if err != nil {
+		return r
+	}
+
+	r = r.WithContext(r.Context())
+	r.Body = body
+	return r
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// shouldRetry reports whether the outcome of an attempt is retryable
+func (policy *RetryPolicy) shouldRetry(r *http.Request, resp *http.Response, err error) bool {
+	if policy == nil {
+		return false
+	}
+
+	if err != nil {
+		// Do not retry if the client has gone away
+		return policy.NetworkErrors && r.Context().Err() == nil
+	}
+
+	return policy.Statuses[resp.StatusCode] || policy.Classes[resp.StatusCode/100]
+}
+
+// backoff returns how long to wait after a failed attempt, using exponential backoff with jitter
+func (policy *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := policy.Backoff
+	for i := 1; i < attempt && backoff < policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+
+	half := int64(backoff / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// withinBudget reports whether another attempt may start after waiting
+func (policy *RetryPolicy) withinBudget(start time.Time, wait time.Duration) bool {
+	return policy.Budget <= 0 || time.Since(start)+wait < policy.Budget
+}
+
+func (policy *RetryPolicy) perTryTimeout() time.Duration {
+	if policy == nil {
+		return 0
+	}
+	return policy.PerTryTimeout
+}
+
+// sleep waits for d, returning false if the context is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// errPerTryTimeout is returned when a single attempt takes longer than the RetryPolicy's PerTryTimeout
+type errPerTryTimeout struct{}
+
+func (errPerTryTimeout) Error() string   { return "per-try timeout exceeded" }
+func (errPerTryTimeout) Timeout() bool   { return true }
+func (errPerTryTimeout) Temporary() bool { return true }
+
+// cancelOnClose cancels an attempt's context once its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// doWithTimeout sends a proxied request, abandoning it if no response arrives within timeout.
+// Unlike a context deadline, the timeout does not apply to reading the response body.
+func doWithTimeout(client *http.Client, request *http.Request, timeout time.Duration) (*http.Response, error) {
+	if timeout <= 0 {
+		return client.Do(request)
+	}
+
+	ctx, cancel := context.WithCancel(request.Context())
+	timer := time.AfterFunc(timeout, cancel)
+
+	resp, err := client.Do(request.WithContext(ctx))
+	if !timer.Stop() {
+		// The timer fired, so the attempt was cancelled or is about to be
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		if request.Context().Err() != nil {
+			return nil, request.Context().Err()
+		}
+		return nil, errPerTryTimeout{}
+	}
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
@@ -0,0 +1,189 @@
+package rsrp_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quells/rsrp"
+)
+
+func retryRule(t *testing.T, retry rsrp.RetryConfig, destinations ...string) *rsrp.RouteRule {
+	configs := make([]rsrp.DestinationConfig, len(destinations))
+	for i, d := range destinations {
+		configs[i] = rsrp.DestinationConfig{URL: d}
+	}
+
+	rule, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:        "^/.*$",
+		Rewrite:      rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+		Destinations: configs,
+		Retry:        &retry,
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+
+	return rule
+}
+
+func TestRouteRule_RetriesOtherDestination(t *testing.T) {
+	var failed, succeeded int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failed, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&succeeded, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer working.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL
+	down.Close()
+
+	rule := retryRule(t, rsrp.RetryConfig{MaxAttempts: 3, Backoff: rsrp.Duration(time.Millisecond)}, failing.URL, downURL, working.URL)
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	for i := 0; i < 6; i++ {
+		req, _ := http.NewRequest(http.MethodPut, server.URL+"/item", strings.NewReader("payload"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || string(body) != "payload" {
+			t.Fatalf("RouteAll() expected retried PUT to succeed with its body, got %d %q", resp.StatusCode, body)
+		}
+	}
+
+	if atomic.LoadInt32(&succeeded) != 6 || atomic.LoadInt32(&failed) == 0 {
+		t.Fatalf("RouteAll() expected every request to reach the working destination, got %d successes and %d failures", succeeded, failed)
+	}
+}
+
+func TestRouteRule_DoesNotRetryNonIdempotent(t *testing.T) {
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	rule := retryRule(t, rsrp.RetryConfig{MaxAttempts: 3, Backoff: rsrp.Duration(time.Millisecond)}, backend.URL)
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/item", "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("RouteAll() expected POST to be sent once, got %d after %d calls", resp.StatusCode, calls)
+	}
+
+	resp, err = http.Get(server.URL + "/item")
+	if err != nil {
+		t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 4 {
+		t.Fatalf("RouteAll() expected GET to be sent 3 times, got %d after %d calls", resp.StatusCode, calls-1)
+	}
+}
+
+func TestRouteRule_RetryPerTryTimeout(t *testing.T) {
+	var calls int32
+	block := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-block:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	defer close(block)
+
+	rule := retryRule(t, rsrp.RetryConfig{
+		MaxAttempts:   2,
+		Backoff:       rsrp.Duration(time.Millisecond),
+		PerTryTimeout: rsrp.Duration(50 * time.Millisecond),
+	}, backend.URL)
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/slow")
+	if err != nil {
+		t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("RouteAll() expected the second attempt to succeed, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestRouteRule_RetryBudget(t *testing.T) {
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer backend.Close()
+
+	rule := retryRule(t, rsrp.RetryConfig{
+		MaxAttempts: 5,
+		Backoff:     rsrp.Duration(time.Second),
+		Budget:      rsrp.Duration(100 * time.Millisecond),
+	}, backend.URL)
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/item")
+	if err != nil {
+		t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("RouteAll() expected no retries beyond the budget, got %d after %d calls", resp.StatusCode, calls)
+	}
+}
+
+func TestNewRetryPolicy(t *testing.T) {
+	policy, err := rsrp.NewRetryPolicy(rsrp.RetryConfig{RetryOn: []string{"5xx", "429"}})
+	if err != nil {
+		t.Fatalf("NewRetryPolicy() unexpected error: %s", err.Error())
+	}
+	if !policy.Classes[5] || !policy.Statuses[429] || policy.NetworkErrors {
+		t.Fatalf("NewRetryPolicy() expected 5xx and 429 only, got %+v", policy)
+	}
+
+	policy, _ = rsrp.NewRetryPolicy(rsrp.RetryConfig{})
+	if policy.MaxAttempts != 2 || !policy.NetworkErrors || !policy.Statuses[503] {
+		t.Fatalf("NewRetryPolicy() expected defaults, got %+v", policy)
+	}
+
+	if _, err := rsrp.NewRetryPolicy(rsrp.RetryConfig{RetryOn: []string{"sometimes"}}); err == nil {
+		t.Fatalf("NewRetryPolicy() expected error for unknown condition")
+	}
+}
@@ -0,0 +1,197 @@
+package rsrp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Router routes requests like RouteAll, but its RouteRules can be replaced while it is serving.
+// Requests which are already in flight, including WebSocket relays, keep using the rules they started with.
+type Router struct {
+	rules atomic.Value // []RouteRule
+
+	mu               sync.Mutex
+	stopHealthChecks func()
+	metrics          *Metrics
+}
+
+// NewRouter creates a Router serving the given RouteRules and starts their health checks
+func NewRouter(rules []RouteRule) *Router {
+	router := &Router{}
+	router.SetRules(rules)
+	return router
+}
+
+// Rules returns the RouteRules currently in use
+func (router *Router) Rules() []RouteRule {
+	rules, _ := router.rules.Load().([]RouteRule)
+	return rules
+}
+
+// SetRules atomically replaces the Router's RouteRules.
+// Health checks are started for the new rules and stopped for the old ones.
+func (router *Router) SetRules(rules []RouteRule) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	router.setRules(rules)
+}
+
+// UseMetrics records the statistics of the Router's current and future RouteRules in m
+func (router *Router) UseMetrics(m *Metrics) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	router.metrics = m
+	router.setRules(router.Rules())
+}
+
+func (router *Router) setRules(rules []RouteRule) {
+	if router.metrics != nil {
+		// Copy so that the caller's rules are left untouched
+		rules = append([]RouteRule(nil), rules...)
+		router.metrics.Instrument(rules)
+	}
+
+	router.rules.Store(rules)
+
+	if router.stopHealthChecks != nil {
+		router.stopHealthChecks()
+	}
+	router.stopHealthChecks = StartHealthChecks(rules)
+}
+
+// Load validates a Config with ConvertConfig and, if it is valid, replaces the Router's RouteRules.
+// If the Config is invalid, the current rules are kept and the error is returned.
+func (router *Router) Load(config Config) (err error) {
+	var rules *[]RouteRule
+	rules, err = ConvertConfig(config)
+	if err != nil {
+		return
+	}
+
+	router.SetRules(*rules)
+	return
+}
+
+// LoadFile reads a JSON Config from a file and loads it into the Router
+func (router *Router) LoadFile(filename string) (err error) {
+	var data []byte
+	data, err = ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	config := Config{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return
+	}
+
+	return router.Load(config)
+}
+
+// WatchFile polls a JSON Config file and reloads the Router whenever the file changes.
+// Errors reading or validating the file are passed to onError, which may be nil.
+// The returned function stops watching.
+func (router *Router) WatchFile(filename string, interval time.Duration, onError func(error)) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+
+	var lastModified time.Time
+	var lastSize int64
+	if info, err := os.Stat(filename); err == nil {
+		lastModified, lastSize = info.ModTime(), info.Size()
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(filename)
+			if err != nil {
+				if onError != nil {
+					onError(err)
+				}
+				continue
+			}
+
+			if info.ModTime().Equal(lastModified) && info.Size() == lastSize {
+				continue
+			}
+			lastModified, lastSize = info.ModTime(), info.Size()
+
+			if err := router.LoadFile(filename); err != nil && onError != nil {
+				onError(fmt.Errorf("could not reload %s: %v", filename, err))
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// ReloadHandler returns an http.Handler which loads a JSON Config from the body of a PUT or POST request.
+// It responds with 400 and keeps the current rules if the Config is invalid.
+// It should only be exposed on an administrative listener.
+func (router *Router) ReloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		config := Config{}
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := router.Load(config); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// CircuitBreakerHandler returns an http.Handler which lists the state of every destination's CircuitBreaker as JSON.
+// It should only be exposed on an administrative listener.
+func (router *Router) CircuitBreakerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(CircuitBreakerStatuses(router.Rules()))
+	})
+}
+
+// Close stops the health checks for the Router's current RouteRules
+func (router *Router) Close() {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	if router.stopHealthChecks != nil {
+		router.stopHealthChecks()
+		router.stopHealthChecks = nil
+	}
+}
+
+// ServeHTTP conforms Router to http.Handler
+func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	routeAll(router.Rules(), w, r)
+}
@@ -0,0 +1,149 @@
+package rsrp_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quells/rsrp"
+)
+
+func routerGet(t *testing.T, url string) (status int, body string) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Router unexpected error for %s: %s", url, err.Error())
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	return resp.StatusCode, string(data)
+}
+
+func routerConfig(match, destination string) rsrp.Config {
+	return rsrp.Config{
+		Routes: []rsrp.RouteRuleConfig{{
+			Match:       match,
+			Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+			Destination: destination,
+		}},
+	}
+}
+
+func TestRouter_Load(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer backend.Close()
+
+	router := rsrp.NewRouter(nil)
+	defer router.Close()
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	if status, _ := routerGet(t, server.URL+"/a"); status != http.StatusNotFound {
+		t.Fatalf("Router expected %d with no rules, got %d", http.StatusNotFound, status)
+	}
+
+	if err := router.Load(routerConfig("^/a$", backend.URL)); err != nil {
+		t.Fatalf("Load() unexpected error: %s", err.Error())
+	}
+
+	if _, body := routerGet(t, server.URL+"/a"); body != "/a" {
+		t.Fatalf("Router expected /a after Load(), got %s", body)
+	}
+
+	if err := router.Load(routerConfig("^/(b$", backend.URL)); err == nil {
+		t.Fatalf("Load() expected error for invalid match")
+	}
+
+	if _, body := routerGet(t, server.URL+"/a"); body != "/a" {
+		t.Fatalf("Router expected old rules to be kept after invalid Load(), got %s", body)
+	}
+}
+
+func TestRouter_ReloadHandler(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer backend.Close()
+
+	router := rsrp.NewRouter(nil)
+	defer router.Close()
+
+	admin := httptest.NewServer(router.ReloadHandler())
+	defer admin.Close()
+
+	testCases := []struct {
+		body     string
+		expected int
+	}{
+		{fmt.Sprintf(`{"routes": [{"match": "^/b$", "rewrite": {"from": "^(/.*)$", "to": "$1"}, "destination": "%s"}]}`, backend.URL), http.StatusNoContent},
+		{`{"routes": [{"match": "^/(b$"}]}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		resp, err := http.Post(admin.URL, "application/json", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("ReloadHandler() unexpected error: %s", err.Error())
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.expected {
+			t.Fatalf("ReloadHandler() expected %d for %s, got %d", tc.expected, tc.body, resp.StatusCode)
+		}
+	}
+
+	if rules := router.Rules(); len(rules) != 1 || rules[0].Match.String() != "^/b$" {
+		t.Fatalf("ReloadHandler() expected the valid config to be loaded, got %v", rules)
+	}
+}
+
+func TestRouter_WatchFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rsrp")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.json")
+	write := func(match string) {
+		config := fmt.Sprintf(`{"routes": [{"match": "%s", "destination": "http://localhost"}]}`, match)
+		if err := ioutil.WriteFile(filename, []byte(config), 0600); err != nil {
+			t.Fatalf("could not write config: %v", err)
+		}
+	}
+
+	write("^/first$")
+
+	router := rsrp.NewRouter(nil)
+	defer router.Close()
+	if err := router.LoadFile(filename); err != nil {
+		t.Fatalf("LoadFile() unexpected error: %s", err.Error())
+	}
+
+	errs := make(chan error, 10)
+	stop := router.WatchFile(filename, 5*time.Millisecond, func(err error) { errs <- err })
+	defer stop()
+
+	write("^/second/path$")
+	waitFor(t, func() bool { return router.Rules()[0].Match.String() == "^/second/path$" })
+
+	write("^/(broken$")
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WatchFile() expected an error for an invalid config")
+	}
+
+	if match := router.Rules()[0].Match.String(); match != "^/second/path$" {
+		t.Fatalf("WatchFile() expected old rules to be kept, got %s", match)
+	}
+}
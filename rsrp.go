@@ -2,16 +2,29 @@ package rsrp
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
+	"time"
 
 	"github.com/quells/rsrp/relay"
 )
 
-// RedirectRequest copies a request and changes the URL
-func RedirectRequest(r *http.Request, newURL string) (newRequest *http.Request, err error) {
+// A RequestModifier changes a proxied request before it is sent to the destination.
+// The incoming request is provided for reference and should not be modified.
+type RequestModifier func(out, in *http.Request)
+
+// A Filter inspects a request before it is proxied.
+// It returns the request to continue with, which may carry additional context,
+// or nil if it has already responded to the client.
+type Filter func(w http.ResponseWriter, r *http.Request) *http.Request
+
+// RedirectRequest copies a request, changes the URL, and then applies any modifiers in order.
+// Hop-by-hop headers are not copied.
+func RedirectRequest(r *http.Request, newURL string, modifiers ...RequestModifier) (newRequest *http.Request, err error) {
 	newRequest, err = http.NewRequest(r.Method, newURL, r.Body)
 	if err != nil {
 		return
@@ -24,6 +37,14 @@ func RedirectRequest(r *http.Request, newURL string) (newRequest *http.Request,
 			newRequest.Header.Add(k, v)
 		}
 	}
+	removeHopByHopHeaders(newRequest.Header)
+
+	// Trailers are hop-by-hop, but whether the client accepts them is passed on
+	if containsToken(r.Header["Te"], "trailers") {
+		newRequest.Header.Set("Te", "trailers")
+	}
+	newRequest.ContentLength = r.ContentLength
+	newRequest.Trailer = r.Trailer
 
 	newRequest.URL.RawQuery = r.URL.Query().Encode()
 
@@ -31,65 +52,335 @@ func RedirectRequest(r *http.Request, newURL string) (newRequest *http.Request,
 		newRequest.AddCookie(c)
 	}
 
+	for _, modify := range modifiers {
+		modify(newRequest, r)
+	}
+
 	return
 }
 
+var connectionRefused = regexp.MustCompile("connection refused")
+
 // RouteAll routes all requests based on the RouteRules provided
 func RouteAll(rules []RouteRule) func(http.ResponseWriter, *http.Request) {
-	connectionRefused := regexp.MustCompile("connection refused")
-
 	return func(w http.ResponseWriter, r *http.Request) {
-		for _, rule := range rules {
-			if rule.Match.MatchString(r.URL.Path) {
-				newURL, err := url.Parse(rule.RewriteLocation(r.URL.Path))
+		routeAll(rules, w, r)
+	}
+}
+
+// routeAll proxies a request using the first matching RouteRule
+func routeAll(rules []RouteRule, w http.ResponseWriter, r *http.Request) {
+	for _, rule := range rules {
+		if rule.Matches(r) {
+			rule.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	httpError(w, r, fmt.Sprintf("no route found for %s", r.URL.Path), http.StatusNotFound)
+}
+
+// ServeHTTP proxies a request which matched the RouteRule
+func (rule RouteRule) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rule.RequestID.Header != "" {
+		r = rule.assignRequestID(w, r)
+	}
+
+	var span *Span
+	if rule.Tracer != nil {
+		span, r = rule.Tracer.startServerSpan(r, r.Method+" "+rule.name())
+	}
+
+	if rule.AccessLog == nil && rule.Metrics == nil && rule.Tracer == nil {
+		if _, aborted := rule.proxy(w, r); aborted {
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+
+	start := time.Now()
+	rec := &responseRecorder{ResponseWriter: w}
+	rule.Metrics.requestStarted(rule.name())
+
+	var body *countingReader
+	if r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+		body = &countingReader{ReadCloser: r.Body}
+		r = r.WithContext(r.Context())
+		r.Body = body
+	}
+
+	upstream, aborted := rule.proxy(rec, r)
+
+	status := rec.status
+	if rec.hijacked {
+		status = http.StatusSwitchingProtocols
+	}
+	rule.Metrics.requestFinished(rule.name(), upstream, status, time.Since(start))
+	rule.finishServerSpan(span, r, status, upstream)
+
+	// WebSocket sessions are logged when they close
+	if !rec.hijacked && rule.AccessLog != nil {
+		entry := rule.newAccessLogEntry(r, start, upstream)
+		entry.Status = rec.status
+		entry.BytesSent = rec.bytes
+		if body != nil {
+			entry.BytesReceived = body.bytes
+		}
+		rule.AccessLog.Log(entry)
+	}
+
+	if aborted {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// proxy sends a request to one of the RouteRule's destinations and copies the response,
+// returning the URL of the destination which was used, if any.
+// Failed attempts are retried according to the RouteRule's RetryPolicy.
+// If the response body could not be copied, aborted is set, and the caller must abort the
+// connection with http.ErrAbortHandler so that the client does not take the truncated body as complete.
+func (rule RouteRule) proxy(w http.ResponseWriter, r *http.Request) (upstream string, aborted bool) {
+	for _, filter := range rule.filters() {
+		r = filter(w, r)
+		if r == nil {
+			return
+		}
+	}
+
+	destination := rule.Upstream(r)
+	if destination == nil {
+		rule.unavailable(w, r)
+		return
+	}
+	upstream = destination.URL
+
+	if r.Header.Get("Connection") == "Upgrade" && r.Header.Get("Upgrade") == "websocket" {
+		newURL, err := url.Parse(destination.URL + rule.RewritePath(r.URL.Path))
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		options := rule.logWebSocket(rule.WebSocketOptions, r, upstream)
+		if dialer := destination.dialer(); dialer != nil {
+			options.Dialer = dialer
+		}
+		options.DialHeader = rule.dialHeader
+		options = rule.Metrics.observeWebSocket(options, rule.name())
+		handler := relay.NewHandler(newURL.String(), options)
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	attempts, r := rule.Retry.attempts(r)
+	start := time.Now()
+	tried := []*Destination{destination}
+
+	for attempt := 1; ; attempt++ {
+		destination = rule.allowedUpstream(r, destination, &tried)
+		if destination == nil {
+			rule.CircuitBreaker.reject(w, r)
+			return
+		}
+		upstream = destination.URL
+
+		destination.acquire()
+		span, attemptReq := rule.startAttempt(r, destination, attempt)
+		resp, err := rule.send(attemptReq, destination)
+		rule.finishAttempt(span, resp, err)
+
+		// Requests cancelled by the client prove nothing about the destination either way
+		if r.Context().Err() != nil {
+			destination.Breaker.Cancel()
+		} else {
+			destination.Breaker.Record(err == nil && resp.StatusCode < http.StatusInternalServerError)
+		}
+
+		if attempt < attempts && rule.Retry.shouldRetry(r, resp, err) {
+			wait := rule.Retry.backoff(attempt)
+			if rule.Retry.withinBudget(start, wait) {
 				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
+					rule.Metrics.upstreamError(rule.name(), upstream, upstreamErrorKind(err))
+				} else {
+					io.Copy(ioutil.Discard, resp.Body)
+					resp.Body.Close()
 				}
+				destination.release()
 
-				if r.Header.Get("Connection") == "Upgrade" && r.Header.Get("Upgrade") == "websocket" {
-					handler := relay.NewHandler(newURL.String(), rule.WebSocketOptions)
-					handler.ServeHTTP(w, r)
+				if !sleep(r.Context(), wait) {
+					httpError(w, r, "request cancelled while retrying "+r.URL.Path, http.StatusBadGateway)
 					return
 				}
 
-				newRequest, err := RedirectRequest(r, newURL.String())
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+				destination = rule.retryUpstream(r, tried)
+				if destination == nil {
+					rule.unavailable(w, r)
 					return
 				}
+				tried = append(tried, destination)
+				continue
+			}
+		}
 
-				client := &http.Client{}
+		defer destination.release()
 
-				resp, err := client.Do(newRequest)
-				if err != nil {
-					if connectionRefused.MatchString(err.Error()) {
-						http.Error(w, "connection refused for "+r.URL.Path, http.StatusBadGateway)
-						return
-					}
+		if err != nil {
+			rule.writeUpstreamError(w, r, upstream, err)
+			return
+		}
 
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
+		aborted = rule.writeResponse(w, r, resp) != nil
+		return
+	}
+}
 
-				body, err := ioutil.ReadAll(resp.Body)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
+// unavailable responds to a request when none of the RouteRule's destinations are available
+func (rule RouteRule) unavailable(w http.ResponseWriter, r *http.Request) {
+	if rule.circuitOpen() {
+		rule.CircuitBreaker.reject(w, r)
+		return
+	}
 
-				for k, vs := range resp.Header {
-					for _, v := range vs {
-						w.Header().Add(k, v)
-					}
-				}
-				w.WriteHeader(resp.StatusCode)
+	httpError(w, r, "no destination available for "+r.URL.Path, http.StatusServiceUnavailable)
+}
 
-				w.Write(body)
-				return
-			}
+// send makes a single attempt to proxy a request to a Destination
+func (rule RouteRule) send(r *http.Request, destination *Destination) (resp *http.Response, err error) {
+	var newURL *url.URL
+	newURL, err = url.Parse(destination.URL + rule.RewritePath(r.URL.Path))
+	if err != nil {
+		return
+	}
+
+	var newRequest *http.Request
+	newRequest, err = RedirectRequest(openBody(r), newURL.String(), rule.requestModifiers()...)
+	if err != nil {
+		return
+	}
+
+	return doWithTimeout(rule.client(destination), newRequest, rule.Retry.perTryTimeout())
+}
+
+// upstreamErrorKind classifies an error from sending a proxied request for metrics
+func upstreamErrorKind(err error) string {
+	if connectionRefused.MatchString(err.Error()) {
+		return "connection_refused"
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return "timeout"
+	}
+
+	return "other"
+}
+
+// writeUpstreamError responds to the client when a proxied request could not be sent
+func (rule RouteRule) writeUpstreamError(w http.ResponseWriter, r *http.Request, upstream string, err error) {
+	kind := upstreamErrorKind(err)
+	rule.Metrics.upstreamError(rule.name(), upstream, kind)
+
+	switch kind {
+	case "connection_refused":
+		httpError(w, r, "connection refused for "+r.URL.Path, http.StatusBadGateway)
+	case "timeout":
+		httpError(w, r, "timed out waiting for "+r.URL.Path, http.StatusGatewayTimeout)
+	default:
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeResponse applies the RouteRule's ResponseModifiers and copies the response to the client,
+// returning the error if its body could not be copied in full
+func (rule RouteRule) writeResponse(w http.ResponseWriter, r *http.Request, resp *http.Response) (err error) {
+	defer resp.Body.Close()
+
+	for _, modify := range rule.responseModifiers() {
+		if err := modify(resp, r); err != nil {
+			httpError(w, r, err.Error(), http.StatusBadGateway)
+			return nil
 		}
+	}
+
+	copyResponseHeader(w, resp)
+	w.WriteHeader(resp.StatusCode)
 
-		http.Error(w, fmt.Sprintf("no route found for %s", r.URL.Path), http.StatusNotFound)
+	err = copyResponse(w, resp.Body, flushIntervalFor(resp, rule.FlushInterval))
+	if err != nil {
+		// Trailers would mark the truncated body as complete
+		return
+	}
+	copyTrailer(w, resp)
+	return
+}
+
+// filters returns the Filters which apply to the RouteRule, in order
+func (rule RouteRule) filters() (filters []Filter) {
+	if rule.IPFilter != nil {
+		filters = append(filters, rule.filterIP)
+	}
+	if rule.CORS != nil {
+		filters = append(filters, rule.cors)
+	}
+	// Failed authentication attempts count against the limit, except when clients are told apart by verified claims
+	claimLimited := rule.RateLimit != nil && rule.RateLimit.Key == "claim"
+	if rule.RateLimit != nil && !claimLimited {
+		filters = append(filters, rule.rateLimit)
+	}
+	if rule.ClientCert != nil {
+		filters = append(filters, rule.requireClientCert)
+	}
+	if rule.Auth != nil {
+		filters = append(filters, rule.requireAuth)
+	}
+	if rule.JWT != nil {
+		filters = append(filters, rule.requireJWT)
+	}
+	if claimLimited {
+		filters = append(filters, rule.rateLimit)
+	}
+
+	return
+}
+
+// requestModifiers returns the RequestModifiers which apply to the RouteRule, in order
+func (rule RouteRule) requestModifiers() (modifiers []RequestModifier) {
+	modifiers = append(modifiers, rule.setForwardedHeaders)
+	if rule.RequestID.Header != "" {
+		modifiers = append(modifiers, rule.setRequestID)
+	}
+	if rule.ClientCert != nil && rule.ClientCert.Header != "" {
+		modifiers = append(modifiers, rule.forwardClientCert)
+	}
+	if rule.Auth != nil {
+		modifiers = append(modifiers, rule.stripCredentials)
+	}
+	if rule.JWT != nil && len(rule.JWT.ForwardClaims) > 0 {
+		modifiers = append(modifiers, rule.forwardClaims)
+	}
+	if rule.Tracer != nil {
+		modifiers = append(modifiers, rule.propagateTrace)
+	}
+	if rule.RequestHeaders != nil {
+		modifiers = append(modifiers, rule.modifyRequestHeaders)
+	}
+
+	return
+}
+
+// responseModifiers returns the ResponseModifiers which apply to the RouteRule, in order
+func (rule RouteRule) responseModifiers() (modifiers []ResponseModifier) {
+	if rule.ReverseRewrite {
+		modifiers = append(modifiers, rule.reverseRewrite)
+	}
+	if rule.CORS != nil {
+		modifiers = append(modifiers, rule.stripCORSHeaders)
 	}
+	if rule.RequestID.Header != "" {
+		modifiers = append(modifiers, rule.stripRequestID)
+	}
+	if rule.ResponseHeaders != nil {
+		modifiers = append(modifiers, rule.modifyResponseHeaders)
+	}
+
+	return
 }
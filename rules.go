@@ -1,14 +1,76 @@
 package rsrp
 
 import (
+	"fmt"
+	"net/http"
 	"regexp"
+	"time"
 
 	"github.com/quells/rsrp/relay"
 )
 
+// ConvertConfig converts a Config to RouteRules.
+// Routes without their own transport configuration share a single client built from Config.Transport,
+// and every route uses the ForwardedPolicy, AccessLogger, RequestIDPolicy and Tracer built from Config.Forwarded,
+// Config.AccessLog, Config.RequestID and Config.Tracing.
+func ConvertConfig(config Config) (routeRules *[]RouteRule, err error) {
+	routeRules, err = ConvertRules(config.Routes)
+	if err != nil {
+		return
+	}
+
+	if config.Transport != nil {
+		client := NewClient(*config.Transport)
+		for i, route := range config.Routes {
+			if route.Transport == nil {
+				(*routeRules)[i].setTransport(*config.Transport, client)
+			}
+		}
+	}
+
+	var forwarded ForwardedPolicy
+	forwarded, err = NewForwardedPolicy(config.Forwarded)
+	if err != nil {
+		return
+	}
+	var accessLog AccessLogger
+	if config.AccessLog != nil {
+		accessLog, err = NewAccessLogger(*config.AccessLog)
+		if err != nil {
+			return
+		}
+	}
+
+	var requestID RequestIDPolicy
+	if config.RequestID != nil {
+		requestID, err = NewRequestIDPolicy(*config.RequestID)
+		if err != nil {
+			return
+		}
+	}
+
+	var tracer *Tracer
+	if config.Tracing != nil {
+		tracer, err = NewTracer(*config.Tracing)
+		if err != nil {
+			return
+		}
+	}
+
+	for i := range *routeRules {
+		(*routeRules)[i].Forwarded = forwarded
+		(*routeRules)[i].AccessLog = accessLog
+		(*routeRules)[i].RequestID = requestID
+		(*routeRules)[i].Tracer = tracer
+	}
+
+	return
+}
+
 // ConvertRules converts RouteRuleConfigs to RouteRules
 func ConvertRules(routes []RouteRuleConfig) (routeRules *[]RouteRule, err error) {
 	rules := make([]RouteRule, len(routes))
+	names := make(map[string]bool)
 
 	var rule *RouteRule
 	for i, route := range routes {
@@ -17,6 +79,14 @@ func ConvertRules(routes []RouteRuleConfig) (routeRules *[]RouteRule, err error)
 			return
 		}
 
+		if route.Name != "" {
+			if names[route.Name] {
+				err = fmt.Errorf("duplicate route name %s", route.Name)
+				return
+			}
+			names[route.Name] = true
+		}
+
 		rules[i] = *rule
 	}
 
@@ -25,12 +95,58 @@ func ConvertRules(routes []RouteRuleConfig) (routeRules *[]RouteRule, err error)
 	return
 }
 
-// A RouteRule describes which paths to match, how to rewrite the request,
-// and where to reroute the request
+// A RouteRule describes which requests to match, how to rewrite the request,
+// and where to reroute the request.
+// Name, if set, identifies the rule in logs, metrics and the admin API instead of Match.
+// Match is tested against the request path; Host, Methods, Headers and Query are optional
+// additional conditions which must all be satisfied, see Matches.
+// If ReverseRewrite is set, URLs and cookies in responses are mapped back into the public URL space.
+// RequestHeaders and ResponseHeaders, if set, change the headers of proxied requests and their responses.
+// Destination is only used when Balancer is nil.
+// FlushInterval controls how often streamed responses are flushed to the client;
+// see copyResponse.
+// Client is used to proxy requests; if it is nil, a shared default client is used.
+// Forwarded controls the X-Forwarded-* and Forwarded headers added to proxied requests,
+// and RequestID how requests are identified.
+// If Retry is set, failed requests which can safely be repeated are retried, preferring other destinations.
+// If CircuitBreaker is set, each destination has a CircuitBreaker following that policy.
+// If RateLimit is set, clients which exceed it are rejected before their requests are proxied.
+// If IPFilter is set, only the client addresses it allows may use the route.
+// If CORS is set, rsrp answers CORS preflight requests and sets the CORS headers of responses itself.
+// If ClientCert is set, requests must present a verified client certificate which it allows.
+// If Auth is set, requests must authenticate with basic auth or an API key, which are not proxied.
+// If JWT is set, requests must carry a bearer token which it validates and authorizes.
+// If AccessLog is set, every request handled by the rule is logged to it,
+// and if Metrics is set, the rule's requests are counted and timed.
+// If Tracer is set, each request and each attempt to proxy it is recorded as a Span.
 type RouteRule struct {
+	Name             string
 	Match            *regexp.Regexp
+	Host             *regexp.Regexp
+	Methods          []string
+	Headers          map[string]*regexp.Regexp
+	Query            map[string]*regexp.Regexp
 	Rewrite          RewriteRule
+	ReverseRewrite   bool
+	RequestHeaders   *HeaderRules
+	ResponseHeaders  *HeaderRules
 	Destination      string
+	Balancer         *Balancer
+	FlushInterval    time.Duration
+	Client           *http.Client
+	Retry            *RetryPolicy
+	CircuitBreaker   *CircuitBreakerPolicy
+	RateLimit        *RateLimiter
+	IPFilter         *IPFilter
+	CORS             *CORSPolicy
+	ClientCert       *ClientCertPolicy
+	Auth             *AuthPolicy
+	JWT              *JWTPolicy
+	Forwarded        ForwardedPolicy
+	RequestID        RequestIDPolicy
+	AccessLog        AccessLogger
+	Metrics          *Metrics
+	Tracer           *Tracer
 	WebSocketOptions relay.Options
 }
 
@@ -42,19 +158,146 @@ func NewRouteRule(config RouteRuleConfig) (rule *RouteRule, err error) {
 		return
 	}
 
+	var host *regexp.Regexp
+	if config.Host != "" {
+		host, err = compileHost(config.Host)
+		if err != nil {
+			return
+		}
+	}
+
+	var headers, query map[string]*regexp.Regexp
+	headers, err = compileValues(config.Headers)
+	if err != nil {
+		return
+	}
+	query, err = compileValues(config.Query)
+	if err != nil {
+		return
+	}
+
 	var rewrite *RewriteRule
 	rewrite, err = NewRewriteRule(config.Rewrite)
 	if err != nil {
 		return
 	}
 
+	destinations := config.Destinations
+	if config.Destination != "" {
+		if len(destinations) > 0 {
+			err = fmt.Errorf("route %s has both destination and destinations", config.Match)
+			return
+		}
+		destinations = []DestinationConfig{{URL: config.Destination}}
+	}
+	if len(destinations) == 0 {
+		err = fmt.Errorf("route %s has no destination", config.Match)
+		return
+	}
+
+	var balancer *Balancer
+	balancer, err = NewBalancer(destinations, config.Balance)
+	if err != nil {
+		return
+	}
+
+	if config.HealthCheck != nil {
+		balancer.HealthCheck, err = NewHealthCheck(*config.HealthCheck)
+		if err != nil {
+			return
+		}
+	}
+
 	rule = &RouteRule{
+		Name:             config.Name,
 		Match:            match,
+		Host:             host,
+		Methods:          config.Methods,
+		Headers:          headers,
+		Query:            query,
 		Rewrite:          *rewrite,
+		ReverseRewrite:   config.ReverseRewrite,
 		Destination:      config.Destination,
+		Balancer:         balancer,
+		FlushInterval:    time.Duration(config.FlushInterval),
 		WebSocketOptions: relay.DefaultOptions(),
 	}
 
+	if config.RequestHeaders != nil {
+		rule.RequestHeaders = NewHeaderRules(*config.RequestHeaders)
+	}
+	if config.ResponseHeaders != nil {
+		rule.ResponseHeaders = NewHeaderRules(*config.ResponseHeaders)
+	}
+
+	if config.Transport != nil {
+		rule.setTransport(*config.Transport, NewClient(*config.Transport))
+	}
+
+	if config.Retry != nil {
+		rule.Retry, err = NewRetryPolicy(*config.Retry)
+		if err != nil {
+			return
+		}
+	}
+
+	if config.CircuitBreaker != nil {
+		rule.CircuitBreaker, err = NewCircuitBreakerPolicy(*config.CircuitBreaker)
+		if err != nil {
+			return
+		}
+
+		for _, d := range balancer.Destinations {
+			d.Breaker = NewCircuitBreaker(rule.CircuitBreaker)
+		}
+	}
+
+	if config.RateLimit != nil {
+		rule.RateLimit, err = NewRateLimiter(*config.RateLimit)
+		if err != nil {
+			return
+		}
+		if rule.RateLimit.Key == "claim" && config.JWT == nil {
+			err = fmt.Errorf("route %s has a rate limit keyed on a claim without jwt validation", config.Match)
+			return
+		}
+	}
+
+	if config.IPFilter != nil {
+		rule.IPFilter, err = NewIPFilter(*config.IPFilter)
+		if err != nil {
+			return
+		}
+	}
+
+	if config.CORS != nil {
+		rule.CORS, err = NewCORSPolicy(*config.CORS)
+		if err != nil {
+			return
+		}
+	}
+
+	if config.ClientCert != nil {
+		rule.ClientCert, err = NewClientCertPolicy(*config.ClientCert)
+		if err != nil {
+			return
+		}
+	}
+
+	if config.Auth != nil {
+		rule.Auth, err = NewAuthPolicy(*config.Auth)
+		if err != nil {
+			return
+		}
+	}
+
+	if config.JWT != nil {
+		rule.JWT, err = NewJWTPolicy(*config.JWT)
+		if err != nil {
+			return
+		}
+	}
+
 	return
 }
 
@@ -63,9 +306,87 @@ func (rule RouteRule) RewritePath(path string) string {
 	return rule.Rewrite.Input.ReplaceAllString(path, rule.Rewrite.Output)
 }
 
-// RewriteLocation converts a request path to the redirected location
+// RewriteLocation converts a request path to the redirected location,
+// choosing the upstream through the rule's Balancer
 func (rule RouteRule) RewriteLocation(path string) string {
-	return rule.Destination + rule.RewritePath(path)
+	destination := rule.Upstream(nil)
+	if destination == nil {
+		return ""
+	}
+
+	return destination.URL + rule.RewritePath(path)
+}
+
+// setTransport sets the client used to proxy requests, rebuilding the clients of Destinations with their own TLS settings
+func (rule *RouteRule) setTransport(config TransportConfig, client *http.Client) {
+	rule.Client = client
+
+	if rule.Balancer == nil {
+		return
+	}
+	for _, d := range rule.Balancer.Destinations {
+		if d.TLS != nil {
+			d.Client = NewTLSClient(config, d.TLS)
+		}
+	}
+}
+
+// client returns the http.Client used to proxy requests to a Destination
+func (rule RouteRule) client(destination *Destination) *http.Client {
+	if destination.Client != nil {
+		return destination.Client
+	}
+	if rule.Client == nil {
+		return sharedClient
+	}
+
+	return rule.Client
+}
+
+// name identifies the RouteRule in logs and metrics
+func (rule RouteRule) name() string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return rule.Match.String()
+}
+
+// Upstream chooses the Destination for a request, or nil if none are available
+func (rule RouteRule) Upstream(r *http.Request) *Destination {
+	if rule.Balancer == nil {
+		return &Destination{URL: rule.Destination}
+	}
+
+	return rule.Balancer.Next(r)
+}
+
+// retryUpstream chooses the Destination for a retried request, preferring Destinations which have not been tried
+func (rule RouteRule) retryUpstream(r *http.Request, tried []*Destination) *Destination {
+	if rule.Balancer == nil {
+		return tried[len(tried)-1]
+	}
+
+	return rule.Balancer.NextExcluding(r, tried)
+}
+
+// allowedUpstream returns destination if its CircuitBreaker allows a request, or otherwise another Destination
+// which has not been tried and whose CircuitBreaker does, adding it to tried.
+// It returns nil if no Destination will take the request.
+func (rule RouteRule) allowedUpstream(r *http.Request, destination *Destination, tried *[]*Destination) *Destination {
+	for !destination.Breaker.Allow() {
+		if rule.Balancer == nil {
+			return nil
+		}
+
+		// Another request may have taken the last half-open probe since the Destination was chosen
+		destination = rule.Balancer.nextUntried(r, *tried)
+		if destination == nil {
+			return nil
+		}
+		*tried = append(*tried, destination)
+	}
+
+	return destination
 }
 
 // A RewriteRule describes how to modify the path for a request
@@ -2,6 +2,7 @@ package rsrp_test
 
 import (
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/quells/rsrp"
@@ -42,3 +43,21 @@ func TestRouteRule_RewriteLocation(t *testing.T) {
 		t.Fatalf("expected %s, found %s", expected, rewritten)
 	}
 }
+
+func TestNewRouteRule_Destinations(t *testing.T) {
+	testCases := []struct {
+		config   rsrp.RouteRuleConfig
+		expected string
+	}{
+		{rsrp.RouteRuleConfig{Match: "^/$"}, "has no destination"},
+		{rsrp.RouteRuleConfig{Match: "^/$", Destinations: []rsrp.DestinationConfig{}}, "has no destination"},
+		{rsrp.RouteRuleConfig{Match: "^/$", Destination: "http://a", Destinations: []rsrp.DestinationConfig{{URL: "http://b"}}}, "has both destination and destinations"},
+	}
+
+	for _, tc := range testCases {
+		_, err := rsrp.NewRouteRule(tc.config)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("NewRouteRule() expected error containing %q for %+v, got %v", tc.expected, tc.config, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package rsrp
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// flushIntervalFor returns how often a response should be flushed to the client.
+// Server-sent events are always flushed immediately.
+func flushIntervalFor(resp *http.Response, configured time.Duration) time.Duration {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		return -1
+	}
+
+	return configured
+}
+
+// copyResponse streams a response body to the client.
+// A negative flushInterval flushes after every write, zero never flushes explicitly,
+// and a positive flushInterval flushes buffered data at most that long after it is written.
+func copyResponse(w http.ResponseWriter, body io.Reader, flushInterval time.Duration) (err error) {
+	var dst io.Writer = w
+
+	if flusher, ok := w.(http.Flusher); ok && flushInterval != 0 {
+		latencyWriter := &maxLatencyWriter{
+			dst:     w,
+			flusher: flusher,
+			latency: flushInterval,
+		}
+		defer latencyWriter.stop()
+
+		dst = latencyWriter
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err = dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+
+		if readErr == io.EOF {
+			return
+		}
+		if readErr != nil {
+			err = readErr
+			return
+		}
+	}
+}
+
+// maxLatencyWriter flushes writes to an http.ResponseWriter after at most latency
+type maxLatencyWriter struct {
+	dst     io.Writer
+	flusher http.Flusher
+	latency time.Duration
+
+	mu           sync.Mutex
+	timer        *time.Timer
+	flushPending bool
+}
+
+func (m *maxLatencyWriter) Write(p []byte) (n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err = m.dst.Write(p)
+	if m.latency < 0 {
+		m.flusher.Flush()
+		return
+	}
+
+	if m.flushPending {
+		return
+	}
+
+	if m.timer == nil {
+		m.timer = time.AfterFunc(m.latency, m.delayedFlush)
+	} else {
+		m.timer.Reset(m.latency)
+	}
+	m.flushPending = true
+
+	return
+}
+
+func (m *maxLatencyWriter) delayedFlush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// stop may have run while the timer fired
+	if !m.flushPending {
+		return
+	}
+
+	m.flusher.Flush()
+	m.flushPending = false
+}
+
+func (m *maxLatencyWriter) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.flushPending = false
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+}
@@ -0,0 +1,193 @@
+package rsrp_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quells/rsrp"
+)
+
+func TestRouteAll_StreamsEvents(t *testing.T) {
+	done := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+
+		// Keep the stream open until the client has seen the first event
+		<-done
+		w.Write([]byte("data: second\n\n"))
+	}))
+	defer backend.Close()
+
+	rule, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match: "^/events$",
+		Rewrite: rsrp.RewriteRuleConfig{
+			Input:  "^(/events)$",
+			Output: "$1",
+		},
+		Destination: backend.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+
+	select {
+	case line := <-lines:
+		if line != "data: first\n" {
+			t.Fatalf("RouteAll() expected first event, got %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("RouteAll() expected first event to be flushed before the response completed")
+	}
+
+	close(done)
+}
+
+func TestRouteAll_FlushInterval(t *testing.T) {
+	done := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	defer backend.Close()
+
+	rule, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match: "^/$",
+		Rewrite: rsrp.RewriteRuleConfig{
+			Input:  "^(/)$",
+			Output: "$1",
+		},
+		Destination:   backend.URL,
+		FlushInterval: rsrp.Duration(10 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+	defer close(done)
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	buf := make([]byte, len("partial"))
+	read := make(chan string)
+	go func() {
+		n, _ := resp.Body.Read(buf)
+		read <- string(buf[:n])
+	}()
+
+	select {
+	case partial := <-read:
+		if partial != "partial" {
+			t.Fatalf("RouteAll() expected partial body, got %q", partial)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("RouteAll() expected partial body to be flushed within the flush interval")
+	}
+}
+
+func TestRouteAll_LargeBody(t *testing.T) {
+	payload := strings.Repeat("0123456789", 100000)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(payload))
+	}))
+	defer backend.Close()
+
+	rule, _ := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:       "^/$",
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/)$", Output: "$1"},
+		Destination: backend.URL,
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(data) != payload {
+		t.Fatalf("RouteAll() expected %d bytes, got %d", len(payload), len(data))
+	}
+}
+
+func TestRouteAll_AbortsTruncatedBody(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+
+		// Close the connection before the final chunk
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer backend.Close()
+
+	rule, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:       "^/.*$",
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+		Destination: backend.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+	instrumented := *rule
+	instrumented.Metrics = rsrp.NewMetrics()
+
+	for _, rule := range []rsrp.RouteRule{*rule, instrumented} {
+		server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{rule})))
+
+		// The abort may reach the client before or after the response headers
+		resp, err := http.Get(server.URL + "/download")
+		if err == nil {
+			var body []byte
+			body, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil || resp.Trailer.Get("X-Checksum") != "" {
+				t.Fatalf("RouteAll() expected a truncated body to abort the response, got %q", body)
+			}
+		}
+		server.Close()
+	}
+
+	if !strings.Contains(scrape(t, instrumented.Metrics), `rsrp_requests_in_flight{route="^/.*$"} 0`) {
+		t.Fatalf("RouteAll() expected an aborted request to finish in the metrics")
+	}
+}
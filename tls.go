@@ -0,0 +1,291 @@
+package rsrp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tlsVersions maps the versions accepted in TLSConfig.MinVersion
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuites maps the names accepted in TLSConfig.CipherSuites.
+// TLS 1.3 cipher suites are not configurable.
+var cipherSuites = map[string]uint16{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+}
+
+// NewTLSConfig converts a TLSConfig to a *tls.Config which chooses certificates from a CertificateStore by SNI
+// and, if a client CA bundle is configured, verifies client certificates
+func NewTLSConfig(config TLSConfig) (tlsConfig *tls.Config, store *CertificateStore, err error) {
+	store, err = NewCertificateStore(config.Certificates)
+	if err != nil {
+		return
+	}
+
+	tlsConfig = &tls.Config{
+		GetCertificate: store.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	if config.MinVersion != "" {
+		version, ok := tlsVersions[config.MinVersion]
+		if !ok {
+			err = fmt.Errorf("unknown TLS version %q", config.MinVersion)
+			return
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	for _, name := range config.CipherSuites {
+		suite, ok := cipherSuites[name]
+		if !ok {
+			err = fmt.Errorf("unknown or unsupported cipher suite %q", name)
+			return
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, suite)
+	}
+
+	err = setClientAuth(tlsConfig, config)
+	return
+}
+
+// NewUpstreamTLSConfig converts an UpstreamTLSConfig to a *tls.Config for connecting to a Destination
+func NewUpstreamTLSConfig(config UpstreamTLSConfig) (tlsConfig *tls.Config, err error) {
+	tlsConfig = &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		var pem []byte
+		pem, err = ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			err = fmt.Errorf("no certificates found in CA bundle %s", config.CAFile)
+			return
+		}
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		var certificate tls.Certificate
+		certificate, err = tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			err = fmt.Errorf("could not load client certificate %s: %v", config.CertFile, err)
+			return
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return
+}
+
+// A CertificateStore holds certificate/key pairs loaded from disk and selects between them by server name.
+// The first certificate is used when a client does not send SNI or no hostname matches.
+type CertificateStore struct {
+	mu      sync.RWMutex
+	entries []*certificateEntry
+}
+
+type certificateEntry struct {
+	hosts            []string
+	certFile         string
+	keyFile          string
+	certModified     time.Time
+	keyModified      time.Time
+	certificate      *tls.Certificate
+	hostsFromLeafDNS bool
+}
+
+// NewCertificateStore loads the certificate/key pairs described by CertificateConfigs
+func NewCertificateStore(configs []CertificateConfig) (store *CertificateStore, err error) {
+	if len(configs) == 0 {
+		err = fmt.Errorf("TLS requires at least one certificate")
+		return
+	}
+
+	store = &CertificateStore{}
+	for _, config := range configs {
+		entry := &certificateEntry{
+			certFile:         config.CertFile,
+			keyFile:          config.KeyFile,
+			hostsFromLeafDNS: len(config.Hosts) == 0,
+		}
+		for _, host := range config.Hosts {
+			entry.hosts = append(entry.hosts, strings.ToLower(host))
+		}
+
+		err = entry.load()
+		if err != nil {
+			return
+		}
+
+		store.entries = append(store.entries, entry)
+	}
+
+	return
+}
+
+// load reads the entry's certificate and key from disk
+func (entry *certificateEntry) load() (err error) {
+	var certInfo, keyInfo os.FileInfo
+	certInfo, err = os.Stat(entry.certFile)
+	if err != nil {
+		return
+	}
+	keyInfo, err = os.Stat(entry.keyFile)
+	if err != nil {
+		return
+	}
+
+	var certificate tls.Certificate
+	certificate, err = tls.LoadX509KeyPair(entry.certFile, entry.keyFile)
+	if err != nil {
+		err = fmt.Errorf("could not load certificate %s: %v", entry.certFile, err)
+		return
+	}
+
+	certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return
+	}
+
+	if entry.hostsFromLeafDNS {
+		entry.hosts = nil
+		for _, name := range certificate.Leaf.DNSNames {
+			entry.hosts = append(entry.hosts, strings.ToLower(name))
+		}
+	}
+
+	entry.certificate = &certificate
+	entry.certModified, entry.keyModified = certInfo.ModTime(), keyInfo.ModTime()
+	return
+}
+
+// changed reports whether the entry's files have been modified since they were loaded
+func (entry *certificateEntry) changed() bool {
+	certInfo, err := os.Stat(entry.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(entry.keyFile)
+	if err != nil {
+		return false
+	}
+
+	return !certInfo.ModTime().Equal(entry.certModified) || !keyInfo.ModTime().Equal(entry.keyModified)
+}
+
+func (entry *certificateEntry) matches(serverName string) bool {
+	for _, host := range entry.hosts {
+		if host == serverName {
+			return true
+		}
+
+		// A wildcard matches exactly one label
+		if strings.HasPrefix(host, "*.") {
+			if i := strings.IndexByte(serverName, '.'); i > 0 && serverName[i:] == host[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetCertificate chooses a certificate for a TLS handshake; it is suitable for tls.Config.GetCertificate
+func (store *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	serverName := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if serverName != "" {
+		for _, entry := range store.entries {
+			if entry.matches(serverName) {
+				return entry.certificate, nil
+			}
+		}
+	}
+
+	return store.entries[0].certificate, nil
+}
+
+// Reload reloads any certificates whose files have changed.
+// If a certificate cannot be loaded, the previous one is kept and the error is returned.
+func (store *CertificateStore) Reload() (err error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for i, entry := range store.entries {
+		if !entry.changed() {
+			continue
+		}
+
+		// Load into a copy so that a failure leaves the current certificate in place
+		reloaded := *entry
+		if loadErr := reloaded.load(); loadErr != nil {
+			if err == nil {
+				err = loadErr
+			}
+			continue
+		}
+		store.entries[i] = &reloaded
+	}
+
+	return
+}
+
+// Watch polls the certificate files every interval and reloads those which change.
+// Errors are passed to onError, which may be nil. The returned function stops watching.
+func (store *CertificateStore) Watch(interval time.Duration, onError func(error)) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			if err := store.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
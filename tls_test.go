@@ -0,0 +1,323 @@
+package rsrp_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/quells/rsrp"
+	"github.com/quells/rsrp/relay"
+)
+
+// writeCertificate writes a self-signed certificate for hosts and its key to dir
+func writeCertificate(t *testing.T, dir, name string, hosts ...string) (certFile, keyFile string) {
+	certFile, keyFile, _, _ = createCertificate(t, dir, name, hosts, nil, nil)
+	return
+}
+
+// A testCA issues certificates for tests
+type testCA struct {
+	file string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	certFile, _, cert, key := createCertificate(t, dir, "ca", nil, nil, nil)
+	return &testCA{certFile, cert, key}
+}
+
+// issue writes a certificate signed by the CA, valid for both servers and clients, and its key to dir
+func (ca *testCA) issue(t *testing.T, dir, name string, hosts ...string) (certFile, keyFile string) {
+	certFile, keyFile, _, _ = createCertificate(t, dir, name, hosts, ca.cert, ca.key)
+	return
+}
+
+// createCertificate writes a certificate and its key to dir; it is a CA if parent is nil and hosts is empty
+func createCertificate(t *testing.T, dir, name string, hosts []string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"rsrp"}},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil && len(hosts) == 0 {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("could not create certificate: %v", err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("could not marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return
+}
+
+func servedName(t *testing.T, store *rsrp.CertificateStore, serverName string) string {
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		t.Fatalf("GetCertificate() unexpected error: %s", err.Error())
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertificateStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+
+	defaultCert, defaultKey := writeCertificate(t, dir, "default", "example.com")
+	wildcardCert, wildcardKey := writeCertificate(t, dir, "wildcard", "*.example.org")
+	apiCert, apiKey := writeCertificate(t, dir, "api", "localhost")
+
+	store, err := rsrp.NewCertificateStore([]rsrp.CertificateConfig{
+		{CertFile: defaultCert, KeyFile: defaultKey},
+		{CertFile: wildcardCert, KeyFile: wildcardKey},
+		{Hosts: []string{"API.example.net"}, CertFile: apiCert, KeyFile: apiKey},
+	})
+	if err != nil {
+		t.Fatalf("NewCertificateStore() unexpected error: %s", err.Error())
+	}
+
+	cases := map[string]string{
+		"example.com":         "default",
+		"www.example.org":     "wildcard",
+		"a.b.example.org":     "default",
+		"api.example.net.":    "api",
+		"":                    "default",
+		"unknown.example.net": "default",
+	}
+	for serverName, expected := range cases {
+		if name := servedName(t, store, serverName); name != expected {
+			t.Fatalf("GetCertificate() expected %s for %q, got %s", expected, serverName, name)
+		}
+	}
+
+	// Replace the default certificate and make sure the change is visible
+	writeCertificate(t, dir, "default", "example.com", "renewed.example.com")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(defaultCert, later, later)
+
+	if err := store.Reload(); err != nil {
+		t.Fatalf("Reload() unexpected error: %s", err.Error())
+	}
+	cert, _ := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "renewed.example.com"})
+	if len(cert.Leaf.DNSNames) != 2 {
+		t.Fatalf("Reload() expected the renewed certificate, got %v", cert.Leaf.DNSNames)
+	}
+
+	// A broken certificate is reported and the previous one kept
+	ioutil.WriteFile(defaultCert, []byte("not a certificate"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(defaultCert, later, later)
+
+	if err := store.Reload(); err == nil {
+		t.Fatalf("Reload() expected error for an invalid certificate")
+	}
+	if name := servedName(t, store, "example.com"); name != "default" {
+		t.Fatalf("Reload() expected to keep the previous certificate, got %s", name)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCertificate(t, dir, "server", "localhost")
+	certificates := []rsrp.CertificateConfig{{CertFile: certFile, KeyFile: keyFile}}
+
+	config, _, err := rsrp.NewTLSConfig(rsrp.TLSConfig{
+		Certificates: certificates,
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	})
+	if err != nil {
+		t.Fatalf("NewTLSConfig() unexpected error: %s", err.Error())
+	}
+	if config.MinVersion != tls.VersionTLS13 || len(config.CipherSuites) != 1 {
+		t.Fatalf("NewTLSConfig() expected TLS 1.3 and one cipher suite, got %x %v", config.MinVersion, config.CipherSuites)
+	}
+
+	invalid := []rsrp.TLSConfig{
+		{},
+		{Certificates: certificates, MinVersion: "1.4"},
+		{Certificates: certificates, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{Certificates: []rsrp.CertificateConfig{{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: keyFile}}},
+	}
+	for _, tlsConfig := range invalid {
+		if _, _, err := rsrp.NewTLSConfig(tlsConfig); err == nil {
+			t.Fatalf("NewTLSConfig() expected error for %+v", tlsConfig)
+		}
+	}
+}
+
+func TestRouteRule_UpstreamMutualTLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "backend", "backend.internal")
+	clientCert, clientKey := ca.issue(t, dir, "proxy")
+
+	serverCertificate, _ := tls.LoadX509KeyPair(serverCert, serverKey)
+	serverTLS := &tls.Config{
+		Certificates: []tls.Certificate{serverCertificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    x509.NewCertPool(),
+	}
+	serverTLS.ClientCAs.AddCert(ca.cert)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			relay.EchoServer{}.ServeHTTP(w, r)
+			return
+		}
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	backend.TLS = serverTLS
+	backend.StartTLS()
+	defer backend.Close()
+
+	upstreamTLS := &rsrp.UpstreamTLSConfig{
+		CAFile:     ca.file,
+		CertFile:   clientCert,
+		KeyFile:    clientKey,
+		ServerName: "backend.internal",
+	}
+	routes, err := rsrp.ConvertConfig(rsrp.Config{
+		Routes: []rsrp.RouteRuleConfig{
+			{
+				Match:        "^/api/.*$",
+				Rewrite:      rsrp.RewriteRuleConfig{Input: "^/api(/.*)$", Output: "$1"},
+				Destinations: []rsrp.DestinationConfig{{URL: backend.URL, TLS: upstreamTLS}},
+			},
+			{
+				Match:        "^/ws$",
+				Rewrite:      rsrp.RewriteRuleConfig{Input: "^(/ws)$", Output: ""},
+				Destinations: []rsrp.DestinationConfig{{URL: "wss" + strings.TrimPrefix(backend.URL, "https"), TLS: upstreamTLS}},
+			},
+		},
+		Transport: &rsrp.TransportConfig{MaxIdleConns: 10},
+	})
+	if err != nil {
+		t.Fatalf("ConvertConfig() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll(*routes)))
+	defer server.Close()
+
+	status, body := routerGet(t, server.URL+"/api/whoami")
+	if status != http.StatusOK || body != "proxy" {
+		t.Fatalf("RouteAll() expected the destination to see the client certificate, got %d %q", status, body)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("could not connect to echo server: %v", err)
+	}
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	if _, message, err := conn.ReadMessage(); err != nil || string(message) != "hello" {
+		t.Fatalf("RouteAll() expected the WebSocket to be relayed over mutual TLS, got %q %v", message, err)
+	}
+
+	if _, err := rsrp.NewUpstreamTLSConfig(rsrp.UpstreamTLSConfig{CAFile: clientKey}); err == nil {
+		t.Fatalf("NewUpstreamTLSConfig() expected error for a CA bundle without certificates")
+	}
+}
+
+func TestRouteRule_UpstreamTLSWebSocketAfterProbe(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rsrp")
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "backend", "backend.internal")
+	serverCertificate, _ := tls.LoadX509KeyPair(serverCert, serverKey)
+
+	negotiated := make(chan string, 1)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			negotiated <- r.TLS.NegotiatedProtocol
+			relay.EchoServer{}.ServeHTTP(w, r)
+		}
+	}))
+	backend.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCertificate},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	backend.StartTLS()
+	defer backend.Close()
+
+	rule, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:   "^/ws$",
+		Rewrite: rsrp.RewriteRuleConfig{Input: "^(/ws)$", Output: ""},
+		Destinations: []rsrp.DestinationConfig{{
+			URL: "wss" + strings.TrimPrefix(backend.URL, "https"),
+			TLS: &rsrp.UpstreamTLSConfig{CAFile: ca.file, ServerName: "backend.internal"},
+		}},
+		HealthCheck: &rsrp.HealthCheckConfig{Path: "/health"},
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+
+	// The HTTP client may negotiate HTTP/2 for health checks
+	if !rule.Balancer.HealthCheck.Probe(rule.Balancer.Destinations[0]) {
+		t.Fatalf("HealthCheck.Probe() expected the destination to be healthy")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("could not connect to echo server: %v", err)
+	}
+	conn.Close()
+
+	select {
+	case protocol := <-negotiated:
+		if protocol == "h2" {
+			t.Fatalf("RouteAll() expected the WebSocket dial to negotiate HTTP/1.1 after a health check, got %q", protocol)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("RouteAll() expected the WebSocket upgrade to reach the destination after a health check")
+	}
+}
@@ -0,0 +1,305 @@
+package rsrp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A TraceID identifies a trace
+type TraceID [16]byte
+
+// A SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// IsZero reports whether the SpanID is unset, as it is for the parent of a root span
+func (id SpanID) IsZero() bool { return id == SpanID{} }
+
+// A SpanKind describes the role of a span in a trace
+type SpanKind int
+
+// SpanKinds of the spans created by rsrp
+const (
+	SpanKindServer SpanKind = iota + 1
+	SpanKindClient
+)
+
+// A Span records an operation within a trace.
+// Server spans cover a request handled by a RouteRule, and client spans each attempt to proxy it.
+// Error is set if the operation failed.
+type Span struct {
+	Name       string
+	Kind       SpanKind
+	TraceID    TraceID
+	SpanID     SpanID
+	ParentID   SpanID
+	TraceState string
+	Sampled    bool
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Error      string
+}
+
+// traceparent formats the W3C traceparent header identifying the Span
+func (span *Span) traceparent() string {
+	flags := 0
+	if span.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", span.TraceID, span.SpanID, flags)
+}
+
+// inject adds the W3C trace context headers which make the Span the parent of a request
+func (span *Span) inject(header http.Header) {
+	header.Set("traceparent", span.traceparent())
+	header.Del("tracestate")
+	if span.TraceState != "" {
+		header.Set("tracestate", span.TraceState)
+	}
+}
+
+// parseTraceparent reads the trace ID, parent span ID and sampled flag of a W3C traceparent header.
+// Later versions of the header are read as version 00, ignoring any extra fields.
+func parseTraceparent(value string) (traceID TraceID, parentID SpanID, sampled bool, ok bool) {
+	value = strings.TrimSpace(value)
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || parts[0] == "ff" || parts[0] == "00" && len(parts) != 4 || strings.ToLower(value) != value {
+		return
+	}
+	if len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return
+	}
+
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(parts[0])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == (TraceID{}) {
+		return
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID.IsZero() {
+		return
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return
+	}
+
+	return traceID, parentID, flags[0]&1 == 1, true
+}
+
+// An Exporter receives Spans which are sampled once they end
+type Exporter interface {
+	Export(span Span)
+}
+
+// An InMemoryExporter keeps exported Spans in memory, which is useful for tests
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+// Export conforms InMemoryExporter to Exporter
+func (e *InMemoryExporter) Export(span Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the Spans exported so far, in the order they ended
+func (e *InMemoryExporter) Spans() []Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Span(nil), e.spans...)
+}
+
+// Reset discards the exported Spans
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// A Tracer records Spans for proxied requests and passes W3C trace context to destinations.
+// Incoming traceparent and tracestate headers are continued, keeping the caller's sampling decision;
+// new traces are sampled with probability SampleRatio. Sampled Spans are passed to Exporter, which may be nil.
+type Tracer struct {
+	Exporter    Exporter
+	SampleRatio float64
+}
+
+// NewTracer converts a TracingConfig to a Tracer.
+// SampleRatio defaults to 1, and Spans are exported over OTLP if an endpoint is configured.
+func NewTracer(config TracingConfig) (tracer *Tracer, err error) {
+	tracer = &Tracer{SampleRatio: config.SampleRatio}
+	if tracer.SampleRatio == 0 {
+		tracer.SampleRatio = 1
+	}
+	if tracer.SampleRatio < 0 || tracer.SampleRatio > 1 {
+		err = fmt.Errorf("sample ratio must be between 0 and 1, got %v", config.SampleRatio)
+		return
+	}
+
+	if config.OTLPEndpoint != "" {
+		tracer.Exporter = NewOTLPExporter(config.OTLPEndpoint, config.ServiceName, config.OTLPHeaders)
+	}
+
+	return
+}
+
+// sample decides whether a new trace is recorded, consistently for the same trace ID
+func (tracer *Tracer) sample(traceID TraceID) bool {
+	if tracer.SampleRatio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])) < tracer.SampleRatio*math.MaxUint64
+}
+
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return
+}
+
+type spanContextKey struct{}
+
+// spanFromRequest returns the Span of the operation a request belongs to, or nil if it is not traced
+func spanFromRequest(r *http.Request) *Span {
+	span, _ := r.Context().Value(spanContextKey{}).(*Span)
+	return span
+}
+
+func withSpan(r *http.Request, span *Span) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), spanContextKey{}, span))
+}
+
+// startServerSpan starts a Span for a request, continuing its trace context if it has one
+func (tracer *Tracer) startServerSpan(r *http.Request, name string) (*Span, *http.Request) {
+	span := &Span{
+		Name:       name,
+		Kind:       SpanKindServer,
+		SpanID:     newSpanID(),
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+	}
+
+	var ok bool
+	span.TraceID, span.ParentID, span.Sampled, ok = parseTraceparent(r.Header.Get("traceparent"))
+	if ok {
+		span.TraceState = r.Header.Get("tracestate")
+	} else {
+		rand.Read(span.TraceID[:])
+		span.ParentID = SpanID{}
+		span.Sampled = tracer.sample(span.TraceID)
+	}
+
+	return span, withSpan(r, span)
+}
+
+// startClientSpan starts a Span which is a child of the Span of a request
+func (tracer *Tracer) startClientSpan(r *http.Request, name string) *Span {
+	parent := spanFromRequest(r)
+	if parent == nil {
+		return nil
+	}
+
+	return &Span{
+		Name:       name,
+		Kind:       SpanKindClient,
+		TraceID:    parent.TraceID,
+		SpanID:     newSpanID(),
+		ParentID:   parent.SpanID,
+		TraceState: parent.TraceState,
+		Sampled:    parent.Sampled,
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+	}
+}
+
+// end finishes a Span and exports it if it is sampled
+func (tracer *Tracer) end(span *Span) {
+	span.End = time.Now()
+	if span.Sampled && tracer.Exporter != nil {
+		tracer.Exporter.Export(*span)
+	}
+}
+
+// finishServerSpan records the outcome of a request handled by the RouteRule
+func (rule RouteRule) finishServerSpan(span *Span, r *http.Request, status int, upstream string) {
+	if span == nil {
+		return
+	}
+
+	span.Attributes["http.method"] = r.Method
+	span.Attributes["http.target"] = r.URL.Path
+	span.Attributes["http.host"] = r.Host
+	span.Attributes["http.status_code"] = status
+	span.Attributes["rsrp.route"] = rule.name()
+	if upstream != "" {
+		span.Attributes["rsrp.destination"] = upstream
+	}
+	if ip := rule.Forwarded.ClientIP(r); ip != nil {
+		span.Attributes["http.client_ip"] = ip.String()
+	}
+	if id := RequestID(r); id != "" {
+		span.Attributes["rsrp.request_id"] = id
+	}
+	if status >= http.StatusInternalServerError {
+		span.Error = http.StatusText(status)
+	}
+
+	rule.Tracer.end(span)
+}
+
+// startAttempt starts a client Span for an attempt to proxy a request to a Destination,
+// returning the request to send, which carries the Span
+func (rule RouteRule) startAttempt(r *http.Request, destination *Destination, attempt int) (*Span, *http.Request) {
+	if rule.Tracer == nil {
+		return nil, r
+	}
+
+	span := rule.Tracer.startClientSpan(r, r.Method+" "+destination.URL)
+	if span == nil {
+		return nil, r
+	}
+
+	span.Attributes["rsrp.route"] = rule.name()
+	span.Attributes["rsrp.destination"] = destination.URL
+	span.Attributes["rsrp.retry"] = attempt - 1
+	return span, withSpan(r, span)
+}
+
+// finishAttempt records the outcome of an attempt to proxy a request
+func (rule RouteRule) finishAttempt(span *Span, resp *http.Response, err error) {
+	if span == nil {
+		return
+	}
+
+	switch {
+	case err != nil:
+		span.Error = err.Error()
+	case resp.StatusCode >= http.StatusInternalServerError:
+		span.Attributes["http.status_code"] = resp.StatusCode
+		span.Error = http.StatusText(resp.StatusCode)
+	default:
+		span.Attributes["http.status_code"] = resp.StatusCode
+	}
+
+	rule.Tracer.end(span)
+}
+
+// propagateTrace is a RequestModifier which passes the trace context of the current attempt to the destination
+func (rule RouteRule) propagateTrace(out, in *http.Request) {
+	if span := spanFromRequest(in); span != nil {
+		span.inject(out.Header)
+	}
+}
@@ -0,0 +1,114 @@
+package rsrp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quells/rsrp"
+)
+
+func TestRouteRule_Tracing(t *testing.T) {
+	var requests int32
+	parents := make(chan string, 2)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parents <- r.Header.Get("traceparent")
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+
+	routes, err := rsrp.ConvertConfig(rsrp.Config{
+		Routes: []rsrp.RouteRuleConfig{{
+			Match:       "^/api/.*$",
+			Rewrite:     rsrp.RewriteRuleConfig{Input: "^/api(/.*)$", Output: "$1"},
+			Destination: backend.URL,
+			Retry:       &rsrp.RetryConfig{MaxAttempts: 2, Backoff: rsrp.Duration(time.Millisecond)},
+		}},
+		Tracing: &rsrp.TracingConfig{},
+	})
+	if err != nil {
+		t.Fatalf("ConvertConfig() unexpected error: %s", err.Error())
+	}
+	exporter := &rsrp.InMemoryExporter{}
+	(*routes)[0].Tracer.Exporter = exporter
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll(*routes)))
+	defer server.Close()
+
+	get := func(traceparent string) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/orders", nil)
+		req.Header.Set("traceparent", traceparent)
+		req.Header.Set("tracestate", "vendor=1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+		}
+		resp.Body.Close()
+	}
+
+	get("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("RouteAll() expected a server span and two client spans, got %d", len(spans))
+	}
+	first, second, serverSpan := spans[0], spans[1], spans[2]
+	if serverSpan.Kind != rsrp.SpanKindServer || serverSpan.TraceID.String() != "0af7651916cd43dd8448eb211c80319c" ||
+		serverSpan.ParentID.String() != "b7ad6b7169203331" || serverSpan.TraceState != "vendor=1" {
+		t.Fatalf("RouteAll() expected the incoming trace to be continued, got %+v", serverSpan)
+	}
+	if serverSpan.Attributes["http.status_code"] != http.StatusOK || serverSpan.Attributes["rsrp.destination"] != backend.URL {
+		t.Fatalf("RouteAll() expected the server span to record the outcome, got %v", serverSpan.Attributes)
+	}
+
+	for i, span := range []rsrp.Span{first, second} {
+		if span.Kind != rsrp.SpanKindClient || span.TraceID != serverSpan.TraceID || span.ParentID != serverSpan.SpanID {
+			t.Fatalf("RouteAll() expected client spans to be children of the server span, got %+v", span)
+		}
+		if span.Attributes["rsrp.retry"] != i || span.Attributes["rsrp.destination"] != backend.URL {
+			t.Fatalf("RouteAll() expected retry %d to be recorded, got %v", i, span.Attributes)
+		}
+		if parent := <-parents; parent != "00-"+span.TraceID.String()+"-"+span.SpanID.String()+"-01" {
+			t.Fatalf("RouteAll() expected the destination to receive the client span as parent, got %q", parent)
+		}
+	}
+	if first.Error == "" || first.Attributes["http.status_code"] != http.StatusServiceUnavailable || second.Error != "" {
+		t.Fatalf("RouteAll() expected only the first attempt to fail, got %q and %q", first.Error, second.Error)
+	}
+
+	exporter.Reset()
+	get("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	if spans := exporter.Spans(); len(spans) != 0 {
+		t.Fatalf("RouteAll() expected unsampled traces not to be exported, got %d spans", len(spans))
+	}
+	if parent := <-parents; !strings.HasSuffix(parent, "-00") || !strings.HasPrefix(parent, "00-0af7651916cd43dd8448eb211c80319c-") {
+		t.Fatalf("RouteAll() expected the sampling decision to be propagated, got %q", parent)
+	}
+
+	get("not-a-traceparent")
+	if spans := exporter.Spans(); len(spans) != 2 || spans[1].TraceID.String() == "0af7651916cd43dd8448eb211c80319c" || !spans[1].ParentID.IsZero() {
+		t.Fatalf("RouteAll() expected an invalid traceparent to start a new trace, got %+v", spans)
+	}
+	<-parents
+}
+
+func TestNewTracer(t *testing.T) {
+	tracer, err := rsrp.NewTracer(rsrp.TracingConfig{})
+	if err != nil || tracer.SampleRatio != 1 || tracer.Exporter != nil {
+		t.Fatalf("NewTracer() expected defaults, got %+v %v", tracer, err)
+	}
+
+	tracer, _ = rsrp.NewTracer(rsrp.TracingConfig{OTLPEndpoint: "http://collector:4318/v1/traces"})
+	if _, ok := tracer.Exporter.(*rsrp.OTLPExporter); !ok {
+		t.Fatalf("NewTracer() expected an OTLPExporter, got %T", tracer.Exporter)
+	}
+
+	if _, err := rsrp.NewTracer(rsrp.TracingConfig{SampleRatio: 1.5}); err == nil {
+		t.Fatalf("NewTracer() expected error for a sample ratio above 1")
+	}
+}
@@ -0,0 +1,80 @@
+package rsrp
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// sharedClient is used by routes which do not configure a transport
+var sharedClient = NewClient(TransportConfig{})
+
+// NewClient converts a TransportConfig to an http.Client for proxying requests.
+// Zero values in the TransportConfig are replaced with defaults suited to a reverse proxy.
+// Unless FollowRedirects is set, redirects from the destination are returned to the caller.
+func NewClient(config TransportConfig) *http.Client {
+	return &http.Client{
+		Transport:     NewTransport(config),
+		CheckRedirect: checkRedirect(config.FollowRedirects),
+	}
+}
+
+// NewTLSClient is like NewClient, but connects to destinations using a copy of tlsConfig,
+// since the transport adds the protocols it negotiates to its TLS config
+func NewTLSClient(config TransportConfig, tlsConfig *tls.Config) *http.Client {
+	client := NewClient(config)
+	client.Transport.(*http.Transport).TLSClientConfig = tlsConfig.Clone()
+	return client
+}
+
+// NewTransport converts a TransportConfig to an http.Transport
+func NewTransport(config TransportConfig) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   durationOr(config.DialTimeout, 30*time.Second),
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   durationOr(config.TLSHandshakeTimeout, 10*time.Second),
+		ResponseHeaderTimeout: time.Duration(config.ResponseHeaderTimeout),
+		IdleConnTimeout:       durationOr(config.IdleConnTimeout, 90*time.Second),
+		MaxIdleConns:          intOr(config.MaxIdleConns, 100),
+		MaxIdleConnsPerHost:   intOr(config.MaxIdleConnsPerHost, 16),
+		ExpectContinueTimeout: time.Second,
+	}
+
+	if config.DisableHTTP2 {
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	} else {
+		attemptHTTP2(transport)
+	}
+
+	return transport
+}
+
+func checkRedirect(follow bool) func(*http.Request, []*http.Request) error {
+	if follow {
+		return nil
+	}
+
+	return func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+}
+
+func durationOr(d Duration, fallback time.Duration) time.Duration {
+	if d <= 0 {
+		return fallback
+	}
+	return time.Duration(d)
+}
+
+func intOr(i, fallback int) int {
+	if i <= 0 {
+		return fallback
+	}
+	return i
+}
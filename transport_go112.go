@@ -0,0 +1,9 @@
+//go:build !go1.13
+// +build !go1.13
+
+package rsrp
+
+import "net/http"
+
+// attemptHTTP2 does nothing before Go 1.13, which enables HTTP/2 for a Transport with a custom DialContext by default
+func attemptHTTP2(transport *http.Transport) {}
@@ -0,0 +1,11 @@
+//go:build go1.13
+// +build go1.13
+
+package rsrp
+
+import "net/http"
+
+// attemptHTTP2 keeps HTTP/2 enabled for a Transport with a custom dialer, which Go 1.13 and later otherwise disable
+func attemptHTTP2(transport *http.Transport) {
+	transport.ForceAttemptHTTP2 = true
+}
@@ -0,0 +1,118 @@
+package rsrp_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/quells/rsrp"
+)
+
+func TestRouteAll_Redirects(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer backend.Close()
+
+	testCases := []struct {
+		transport      *rsrp.TransportConfig
+		expectedStatus int
+	}{
+		{nil, http.StatusFound},
+		{&rsrp.TransportConfig{FollowRedirects: true}, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		routes, err := rsrp.ConvertConfig(rsrp.Config{
+			Routes: []rsrp.RouteRuleConfig{{
+				Match:       "^/.*$",
+				Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+				Destination: backend.URL,
+			}},
+			Transport: tc.transport,
+		})
+		if err != nil {
+			t.Fatalf("ConvertConfig() unexpected error: %s", err.Error())
+		}
+
+		server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll(*routes)))
+		client := server.Client()
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+
+		resp, err := client.Get(server.URL + "/old")
+		if err != nil {
+			t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+		}
+		resp.Body.Close()
+		server.Close()
+
+		if resp.StatusCode != tc.expectedStatus {
+			t.Fatalf("RouteAll() expected status %d with %+v, got %d", tc.expectedStatus, tc.transport, resp.StatusCode)
+		}
+	}
+}
+
+func TestRouteAll_ResponseHeaderTimeout(t *testing.T) {
+	done := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer backend.Close()
+	defer close(done)
+
+	rule, err := rsrp.NewRouteRule(rsrp.RouteRuleConfig{
+		Match:       "^/.*$",
+		Rewrite:     rsrp.RewriteRuleConfig{Input: "^(/.*)$", Output: "$1"},
+		Destination: backend.URL,
+		Transport: &rsrp.TransportConfig{
+			ResponseHeaderTimeout: rsrp.Duration(10 * time.Millisecond),
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRouteRule() unexpected error: %s", err.Error())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(rsrp.RouteAll([]rsrp.RouteRule{*rule})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatalf("RouteAll() unexpected error: %s", err.Error())
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("RouteAll() expected status %d, got %d", http.StatusGatewayTimeout, resp.StatusCode)
+	}
+}
+
+func TestConvertConfig_SharedClient(t *testing.T) {
+	routes, err := rsrp.ConvertConfig(rsrp.Config{
+		Routes: []rsrp.RouteRuleConfig{
+			{Match: "^/a$", Destination: "http://a"},
+			{Match: "^/b$", Destination: "http://b"},
+			{Match: "^/c$", Destination: "http://c", Transport: &rsrp.TransportConfig{DisableHTTP2: true}},
+		},
+		Transport: &rsrp.TransportConfig{MaxIdleConnsPerHost: 64},
+	})
+	if err != nil {
+		t.Fatalf("ConvertConfig() unexpected error: %s", err.Error())
+	}
+
+	rules := *routes
+	if rules[0].Client == nil || rules[0].Client != rules[1].Client {
+		t.Fatalf("ConvertConfig() expected routes without a transport to share the global client")
+	}
+	if rules[2].Client == nil || rules[2].Client == rules[0].Client {
+		t.Fatalf("ConvertConfig() expected a route with its own transport to have its own client")
+	}
+}